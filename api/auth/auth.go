@@ -0,0 +1,211 @@
+// Package auth authenticates callers of the customer API with static API keys or JWT
+// bearer tokens and carries the authenticated principal in the request context.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/vlegro/backend/api/logging"
+	"github.com/vlegro/backend/api/problem"
+	"github.com/vlegro/backend/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// MethodAPIKey is the Principal.Method of callers sending X-API-Key.
+	MethodAPIKey = "api_key"
+	// MethodJWT is the Principal.Method of callers sending a bearer token.
+	MethodJWT = "jwt"
+
+	// APIKeyHeader carries a static API key.
+	APIKeyHeader = "X-API-Key"
+
+	codeUnauthorized = "unauthorized"
+	// leeway tolerates clock skew between the token issuer and this server.
+	leeway = 30 * time.Second
+)
+
+// Principal is an authenticated caller.
+type Principal struct {
+	// Subject is the API key name or the sub claim of the token.
+	Subject string
+	Method  string
+	Roles   []string
+}
+
+// String names the principal in logs and in the audit log, e.g. api_key:ops-script.
+func (p Principal) String() string {
+	return p.Method + ":" + p.Subject
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying principal.
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// PrincipalFrom returns the principal of the request, false for anonymous requests.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(contextKey{}).(Principal)
+	return principal, ok
+}
+
+// Authenticator verifies the credentials of requests.
+type Authenticator struct {
+	required bool
+	apiKeys  []apiKey
+	keys     *keySet
+	parser   *jwt.Parser
+}
+
+type apiKey struct {
+	// hash is the SHA-256 of the key
+	hash      []byte
+	principal Principal
+}
+
+// claims are the JWT claims read besides the registered ones.
+type claims struct {
+	jwt.RegisteredClaims
+	Roles []string `json:"roles"`
+}
+
+// New builds an Authenticator from cfg, reading the JWKS file if one is configured.
+func New(cfg config.Auth) (*Authenticator, error) {
+	a := &Authenticator{required: cfg.Required}
+	for _, key := range cfg.APIKeys {
+		hash, err := hex.DecodeString(key.SHA256)
+		if err != nil {
+			return nil, fmt.Errorf("api key %s: invalid hash: %w", key.Name, err)
+		}
+		a.apiKeys = append(a.apiKeys, apiKey{
+			hash:      hash,
+			principal: Principal{Subject: key.Name, Method: MethodAPIKey, Roles: key.Roles},
+		})
+	}
+
+	keys := &keySet{hmac: []byte(cfg.JWT.Secret)}
+	if cfg.JWT.JWKSFile != "" {
+		var err error
+		if keys.byKid, err = loadJWKS(cfg.JWT.JWKSFile); err != nil {
+			return nil, err
+		}
+	}
+	if methods := keys.methods(); len(methods) > 0 {
+		options := []jwt.ParserOption{
+			jwt.WithValidMethods(methods),
+			jwt.WithExpirationRequired(),
+			jwt.WithLeeway(leeway),
+		}
+		if cfg.JWT.Issuer != "" {
+			options = append(options, jwt.WithIssuer(cfg.JWT.Issuer))
+		}
+		if cfg.JWT.Audience != "" {
+			options = append(options, jwt.WithAudience(cfg.JWT.Audience))
+		}
+		a.keys = keys
+		a.parser = jwt.NewParser(options...)
+	}
+	return a, nil
+}
+
+// Middleware puts the principal of the request in its context. Requests with invalid
+// credentials, or without any when they are required, get a 401.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, found, err := a.authenticate(r)
+		if err != nil || (!found && a.required) {
+			unauthorized(w, r, err)
+			return
+		}
+		if !found {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		logging.SetResult(r.Context(), slog.String("principal", principal.String()))
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("enduser.id", principal.String()))
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+var (
+	errInvalidAPIKey = errors.New("invalid API key")
+	errInvalidToken  = errors.New("invalid bearer token")
+)
+
+// authenticate returns the principal of r, found is false when r carries no credentials.
+func (a *Authenticator) authenticate(r *http.Request) (_ Principal, found bool, _ error) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		principal, err := a.verifyAPIKey(key)
+		return principal, true, err
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" {
+		return Principal{}, false, nil
+	}
+	scheme, token, _ := strings.Cut(authorization, " ")
+	if !strings.EqualFold(scheme, "Bearer") || a.parser == nil {
+		return Principal{}, true, errInvalidToken
+	}
+	principal, err := a.verifyToken(strings.TrimSpace(token))
+	if err != nil {
+		logging.FromContext(r.Context()).Debug("Bearer token rejected", "error", err)
+		return Principal{}, true, errInvalidToken
+	}
+	return principal, true, nil
+}
+
+func (a *Authenticator) verifyAPIKey(key string) (Principal, error) {
+	hash := sha256.Sum256([]byte(key))
+	// Compare with every key in constant time, the time taken tells nothing about the keys
+	var principal Principal
+	found := false
+	for _, k := range a.apiKeys {
+		if subtle.ConstantTimeCompare(hash[:], k.hash) == 1 {
+			principal = k.principal
+			found = true
+		}
+	}
+	if !found {
+		return Principal{}, errInvalidAPIKey
+	}
+	return principal, nil
+}
+
+func (a *Authenticator) verifyToken(token string) (Principal, error) {
+	var c claims
+	if _, err := a.parser.ParseWithClaims(token, &c, a.keys.keyfunc); err != nil {
+		return Principal{}, err
+	}
+	if c.Subject == "" {
+		return Principal{}, errors.New("token has no sub claim")
+	}
+	return Principal{Subject: c.Subject, Method: MethodJWT, Roles: c.Roles}, nil
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	challenge := `Bearer realm="backend"`
+	message := "authentication required, send an X-API-Key header or a bearer token"
+	if err != nil {
+		message = err.Error()
+		if errors.Is(err, errInvalidToken) {
+			challenge += `, error="invalid_token"`
+		}
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	problem.New(r, http.StatusUnauthorized, codeUnauthorized, message).Write(w, r)
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vlegro/backend/config"
+)
+
+// Permission allows one kind of operation.
+type Permission string
+
+const (
+	PermissionRead   Permission = "customers:read"
+	PermissionWrite  Permission = "customers:write"
+	PermissionDelete Permission = "customers:delete"
+	PermissionAudit  Permission = "audit:read"
+)
+
+var knownPermissions = map[Permission]bool{
+	PermissionRead:   true,
+	PermissionWrite:  true,
+	PermissionDelete: true,
+	PermissionAudit:  true,
+}
+
+// Role is a named set of permissions.
+type Role struct {
+	Permissions []Permission
+	// DeletePrefixes restricts PermissionDelete to customers matching one of these prefixes,
+	// empty allows deleting any customer.
+	DeletePrefixes []string
+}
+
+// Policy maps the roles of principals to what they may do.
+type Policy struct {
+	roles map[string]Role
+	// anonymousRoles are granted to requests without a principal
+	anonymousRoles []string
+}
+
+// NewPolicy checks that roles only use known permissions.
+func NewPolicy(roles map[string]Role, anonymousRoles []string) (*Policy, error) {
+	for name, role := range roles {
+		for _, permission := range role.Permissions {
+			if !knownPermissions[permission] {
+				return nil, fmt.Errorf("role %s: unknown permission %q", name, permission)
+			}
+		}
+	}
+	return &Policy{roles: roles, anonymousRoles: anonymousRoles}, nil
+}
+
+// PolicyFrom builds the Policy configured in cfg.
+func PolicyFrom(cfg config.Auth) (*Policy, error) {
+	roles := make(map[string]Role, len(cfg.Roles))
+	for name, role := range cfg.Roles {
+		permissions := make([]Permission, len(role.Permissions))
+		for i, permission := range role.Permissions {
+			permissions[i] = Permission(permission)
+		}
+		roles[name] = Role{Permissions: permissions, DeletePrefixes: role.DeletePrefixes}
+	}
+	return NewPolicy(roles, cfg.AnonymousRoles)
+}
+
+// Grant is what the caller may do, the union of its roles.
+type Grant struct {
+	permissions map[Permission]bool
+	// deletePrefixes is nil when some role deletes without restriction
+	deletePrefixes []string
+}
+
+// GrantOf returns the grant of the principal of ctx, or of anonymous callers. Roles that
+// the policy does not define grant nothing.
+func (p *Policy) GrantOf(ctx context.Context) Grant {
+	roles := p.anonymousRoles
+	if principal, ok := PrincipalFrom(ctx); ok {
+		roles = principal.Roles
+	}
+
+	grant := Grant{permissions: map[Permission]bool{}}
+	unrestricted := false
+	for _, name := range roles {
+		role, ok := p.roles[name]
+		if !ok {
+			continue
+		}
+		for _, permission := range role.Permissions {
+			grant.permissions[permission] = true
+			if permission != PermissionDelete {
+				continue
+			}
+			if len(role.DeletePrefixes) == 0 {
+				unrestricted = true
+			}
+			grant.deletePrefixes = append(grant.deletePrefixes, role.DeletePrefixes...)
+		}
+	}
+	if unrestricted {
+		grant.deletePrefixes = nil
+	}
+	return grant
+}
+
+// Allows reports whether the grant holds permission.
+func (g Grant) Allows(permission Permission) bool {
+	return g.permissions[permission]
+}
+
+// DeletePrefixes returns the prefixes deletes are restricted to, restricted is false when
+// any customer may be deleted.
+func (g Grant) DeletePrefixes() (prefixes []string, restricted bool) {
+	return g.deletePrefixes, g.deletePrefixes != nil
+}
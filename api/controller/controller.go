@@ -2,24 +2,99 @@ package controller
 
 import (
 	"github.com/go-chi/chi/v5"
+	"github.com/vlegro/backend/api/auth"
 	"github.com/vlegro/backend/api/handlers"
+	"github.com/vlegro/backend/api/ratelimit"
 	"github.com/vlegro/backend/api/service"
+	"github.com/vlegro/backend/config"
 )
 
 type CustomerController struct {
 	customerHandler *handlers.CustomerHandler
+	auditHandler    *handlers.AuditHandler
+	authenticator   *auth.Authenticator
+	rateLimitStore  ratelimit.Store
+	limits          Limits
+	timeouts        Timeouts
+	restore         bool
 }
 
-func NewCustomerController(customerHandler *service.CustomerService) *CustomerController {
-	return &CustomerController{customerHandler: handlers.NewCustomerHandler(customerHandler)}
+// Option configures a CustomerController.
+type Option func(*CustomerController)
+
+// WithTimeouts replaces the deadlines of config.Default.
+func WithTimeouts(timeouts Timeouts) Option {
+	return func(cc *CustomerController) {
+		cc.timeouts = timeouts
+	}
+}
+
+// WithRestore mounts or leaves out POST /customers/restore, it is mounted by default.
+func WithRestore(enabled bool) Option {
+	return func(cc *CustomerController) {
+		cc.restore = enabled
+	}
+}
+
+// WithAudit mounts GET /audit, it is left out without an audit service.
+func WithAudit(auditService *service.AuditService) Option {
+	return func(cc *CustomerController) {
+		cc.auditHandler = handlers.NewAuditHandler(auditService)
+	}
+}
+
+// WithAuthenticator requires every route to pass authenticator, routes are open by default.
+func WithAuthenticator(authenticator *auth.Authenticator) Option {
+	return func(cc *CustomerController) {
+		cc.authenticator = authenticator
+	}
+}
+
+func NewCustomerController(customerHandler *service.CustomerService, options ...Option) *CustomerController {
+	cc := &CustomerController{
+		customerHandler: handlers.NewCustomerHandler(customerHandler),
+		timeouts:        NewTimeouts(config.Default().HTTP.Timeouts),
+		restore:         true,
+	}
+	for _, option := range options {
+		option(cc)
+	}
+	return cc
 }
 
 func (cc *CustomerController) RestController() chi.Router {
 	router := chi.NewRouter()
 
-	// Add routes
-	router.Get("/customers", cc.customerHandler.HandleGetByPrefix)
-	router.Delete("/customers", cc.customerHandler.HandleDeleteByPrefix)
+	router.NotFound(handlers.NotFound)
+	router.MethodNotAllowed(handlers.MethodNotAllowed)
+	if cc.authenticator != nil {
+		// Failed authentications count against the address before credentials are checked
+		router.Use(cc.authenticationLimit(), cc.authenticator.Middleware)
+	}
+
+	// Add routes, limited after authentication so that callers are told apart by principal
+	router.With(cc.rateLimit("getByPrefix", cc.limits.GetByPrefix), withTimeout(cc.timeouts.GetByPrefix)).
+		Get("/customers", cc.customerHandler.HandleGetByPrefix)
+	router.With(cc.rateLimit("deleteByPrefix", cc.limits.DeleteByPrefix), withTimeout(cc.timeouts.DeleteByPrefix)).
+		Delete("/customers", cc.customerHandler.HandleDeleteByPrefix)
+	router.With(cc.rateLimit("write", cc.limits.Write), withTimeout(cc.timeouts.Write)).
+		Post("/customers", cc.customerHandler.HandleCreate)
+	if cc.restore {
+		router.With(cc.rateLimit("restore", cc.limits.Restore), withTimeout(cc.timeouts.Restore)).
+			Post("/customers/restore", cc.customerHandler.HandleRestore)
+	}
+	router.With(cc.rateLimit("read", cc.limits.Read), withTimeout(cc.timeouts.Read)).
+		Get("/customers/{id}", cc.customerHandler.HandleGetById)
+	router.With(cc.rateLimit("write", cc.limits.Write), withTimeout(cc.timeouts.Write)).
+		Put("/customers/{id}", cc.customerHandler.HandleUpdate)
+	router.With(cc.rateLimit("write", cc.limits.Write), withTimeout(cc.timeouts.Write)).
+		Patch("/customers/{id}", cc.customerHandler.HandlePatch)
+	router.With(cc.rateLimit("deleteById", cc.limits.DeleteById), withTimeout(cc.timeouts.Write)).
+		Delete("/customers/{id}", cc.customerHandler.HandleDeleteById)
+	if cc.auditHandler != nil {
+		router.With(cc.rateLimit("audit", cc.limits.Audit), withTimeout(cc.timeouts.Audit)).
+			Get("/audit", cc.auditHandler.HandleList)
+	}
 
 	return router
 }
@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vlegro/backend/api/auth"
+	"github.com/vlegro/backend/api/logging"
+	"github.com/vlegro/backend/api/ratelimit"
+	"github.com/vlegro/backend/api/repository"
+	"github.com/vlegro/backend/api/service"
+	"github.com/vlegro/backend/config"
+)
+
+const apiKey = "s3cr3t-key"
+
+// apiKeys configures apiKey for the caller ops-script.
+func apiKeys() []config.APIKey {
+	hash := sha256.Sum256([]byte(apiKey))
+	return []config.APIKey{{Name: "ops-script", SHA256: hex.EncodeToString(hash[:]), Roles: []string{"admin"}}}
+}
+
+func TestController_ClientCancelled(t *testing.T) {
+	router, mock, _ := newTracedRouter(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/customers/1", nil).WithContext(ctx))
+
+	// The access log and the metrics must not count the request as a success
+	assert.Equal(t, 499, recorder.Code)
+	assert.Empty(t, recorder.Body.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestController_AuditActor(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	authenticator, err := auth.New(config.Auth{APIKeys: apiKeys()})
+	require.NoError(t, err)
+	customerService := service.NewCustomerService(repository.NewCustomerRepositoryImpl(db))
+	router := chi.NewRouter()
+	router.Use(logging.RequestID)
+	router.Mount("/", NewCustomerController(customerService, WithAuthenticator(authenticator)).RestController())
+
+	// The deletion is recorded with the caller and the request id of the request
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE customer SET deleted_at = now\(\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "patronymic_name", "phone", "email", "deleted_at"}).
+			AddRow(5, "Клиент", nil, nil, nil, nil, time.Now()))
+	mock.ExpectExec(`INSERT INTO customer_audit`).
+		WithArgs("delete_by_id", "api_key:ops-script", "delete-5", "{}", "{}", "{5}", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	request := httptest.NewRequest(http.MethodDelete, "/customers/5", nil)
+	request.Header.Set(auth.APIKeyHeader, apiKey)
+	request.Header.Set(logging.RequestIdHeader, "delete-5")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// newDefaultRouter wires authentication and authorization as configured by cfg, on a database
+// that expects no query.
+func newDefaultRouter(t *testing.T, cfg config.Auth) (http.Handler, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	authenticator, err := auth.New(cfg)
+	require.NoError(t, err)
+	policy, err := auth.PolicyFrom(cfg)
+	require.NoError(t, err)
+
+	customerService := service.NewCustomerService(repository.NewCustomerRepositoryImpl(db), service.WithPolicy(policy))
+	auditService := service.NewAuditService(repository.NewAuditRepositoryImpl(db), policy)
+	controller := NewCustomerController(customerService, WithAuthenticator(authenticator), WithAudit(auditService))
+	return controller.RestController(), mock
+}
+
+func TestController_DefaultsDenyAnonymous(t *testing.T) {
+	requests := []struct {
+		method string
+		target string
+	}{
+		{http.MethodDelete, "/customers?prefix=Кл"},
+		{http.MethodDelete, "/customers/1"},
+		{http.MethodPost, "/customers/restore?prefix=Кл"},
+		{http.MethodGet, "/audit"},
+		{http.MethodGet, "/customers?prefix=Кл"},
+	}
+
+	cfg := config.Default().Auth
+	cfg.APIKeys = apiKeys()
+	required := cfg
+	required.Required = true
+
+	for _, tt := range []struct {
+		name           string
+		cfg            config.Auth
+		expectedStatus int
+	}{
+		{name: "anonymous callers have no roles", cfg: cfg, expectedStatus: http.StatusForbidden},
+		{name: "credentials required", cfg: required, expectedStatus: http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			router, mock := newDefaultRouter(t, tt.cfg)
+
+			for _, request := range requests {
+				recorder := httptest.NewRecorder()
+				router.ServeHTTP(recorder, httptest.NewRequest(request.method, request.target, nil))
+
+				assert.Equal(t, tt.expectedStatus, recorder.Code, "%s %s", request.method, request.target)
+			}
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+// newAuthenticationLimitedRouter requires an API key and allows two failed authentications
+// per address.
+func newAuthenticationLimitedRouter(t *testing.T) chi.Router {
+	authenticator, err := auth.New(config.Auth{APIKeys: apiKeys()})
+	require.NoError(t, err)
+	limits := NewLimits(config.Default().RateLimit.Limits)
+	limits.Authentication = ratelimit.Limit{Rate: 0.1, Burst: 2}
+	return NewCustomerController(service.NewCustomerService(nil),
+		WithAuthenticator(authenticator), WithRateLimit(ratelimit.NewMemoryStore(), limits)).RestController()
+}
+
+func TestController_AuthenticationLimit(t *testing.T) {
+	router := newAuthenticationLimitedRouter(t)
+	serve := func(key string) int {
+		request := httptest.NewRequest(http.MethodGet, "/customers/1", nil)
+		request.Header.Set(auth.APIKeyHeader, key)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	// Rejected credentials spend the tokens of the address, not of a principal
+	assert.Equal(t, http.StatusUnauthorized, serve("guess-1"))
+	assert.Equal(t, http.StatusUnauthorized, serve("guess-2"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("guess-3"))
+	assert.Equal(t, http.StatusTooManyRequests, serve(apiKey))
+}
+
+func TestController_AuthenticationLimit_Concurrent(t *testing.T) {
+	router := newAuthenticationLimitedRouter(t)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		statuses = map[int]int{}
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			request := httptest.NewRequest(http.MethodGet, "/customers/1", nil)
+			request.Header.Set(auth.APIKeyHeader, "guess")
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			mu.Lock()
+			defer mu.Unlock()
+			statuses[recorder.Code]++
+		}()
+	}
+	wg.Wait()
+
+	// Guesses sent at once get no more attempts than the burst
+	assert.LessOrEqual(t, statuses[http.StatusUnauthorized], 2)
+	assert.Equal(t, 20, statuses[http.StatusUnauthorized]+statuses[http.StatusTooManyRequests])
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/vlegro/backend/api/ratelimit"
+	"github.com/vlegro/backend/config"
+)
+
+// Limits caps the request rate of every client per endpoint, a zero Limit leaves the endpoint
+// unlimited.
+type Limits struct {
+	// GetByPrefix limits GET /customers.
+	GetByPrefix ratelimit.Limit
+	// DeleteByPrefix limits DELETE /customers.
+	DeleteByPrefix ratelimit.Limit
+	// Restore limits POST /customers/restore.
+	Restore ratelimit.Limit
+	// Read limits GET /customers/{id}.
+	Read ratelimit.Limit
+	// Write limits POST /customers and PUT and PATCH /customers/{id}.
+	Write ratelimit.Limit
+	// DeleteById limits DELETE /customers/{id}.
+	DeleteById ratelimit.Limit
+	// Audit limits GET /audit.
+	Audit ratelimit.Limit
+	// Authentication limits the requests of every address that fail authentication, whatever
+	// the endpoint.
+	Authentication ratelimit.Limit
+}
+
+// NewLimits converts the configured limits, config.Default holds the defaults.
+func NewLimits(l config.Limits) Limits {
+	limit := func(c config.Limit) ratelimit.Limit {
+		return ratelimit.Limit{Rate: c.Rate, Burst: c.Burst}
+	}
+	return Limits{
+		GetByPrefix:    limit(l.GetByPrefix),
+		DeleteByPrefix: limit(l.DeleteByPrefix),
+		Restore:        limit(l.Restore),
+		Read:           limit(l.Read),
+		Write:          limit(l.Write),
+		DeleteById:     limit(l.DeleteById),
+		Audit:          limit(l.Audit),
+		Authentication: limit(l.Authentication),
+	}
+}
+
+// WithRateLimit limits the endpoints to limits, keeping the buckets in store. Endpoints are
+// not limited by default.
+func WithRateLimit(store ratelimit.Store, limits Limits) Option {
+	return func(cc *CustomerController) {
+		cc.rateLimitStore = store
+		cc.limits = limits
+	}
+}
+
+// rateLimit applies limit to the endpoint called name, unless rate limiting is off.
+func (cc *CustomerController) rateLimit(name string, limit ratelimit.Limit) func(http.Handler) http.Handler {
+	if cc.rateLimitStore == nil {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return ratelimit.Middleware(cc.rateLimitStore, name, limit)
+}
+
+// authenticationLimit limits failed authentications, unless rate limiting is off.
+func (cc *CustomerController) authenticationLimit() func(http.Handler) http.Handler {
+	if cc.rateLimitStore == nil {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return ratelimit.Unauthorized(cc.rateLimitStore, "authentication", cc.limits.Authentication)
+}
@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/vlegro/backend/config"
+)
+
+// Timeouts bounds how long each endpoint may take before the request context expires
+// and the handler answers 504. A zero duration leaves the endpoint without a deadline.
+type Timeouts struct {
+	// GetByPrefix is the deadline of GET /customers.
+	GetByPrefix time.Duration
+	// DeleteByPrefix is the deadline of DELETE /customers.
+	DeleteByPrefix time.Duration
+	// Restore is the deadline of POST /customers/restore.
+	Restore time.Duration
+	// Read is the deadline of GET /customers/{id}.
+	Read time.Duration
+	// Write is the deadline of POST /customers and PUT, PATCH and DELETE /customers/{id}.
+	Write time.Duration
+	// Audit is the deadline of GET /audit.
+	Audit time.Duration
+}
+
+// NewTimeouts converts the configured deadlines, config.Default holds the defaults.
+func NewTimeouts(t config.Timeouts) Timeouts {
+	return Timeouts{
+		GetByPrefix:    time.Duration(t.GetByPrefix),
+		DeleteByPrefix: time.Duration(t.DeleteByPrefix),
+		Restore:        time.Duration(t.Restore),
+		Read:           time.Duration(t.Read),
+		Write:          time.Duration(t.Write),
+		Audit:          time.Duration(t.Audit),
+	}
+}
+
+// withTimeout attaches a deadline to the request context, the database driver
+// cancels the running query once it expires or the client disconnects.
+func withTimeout(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
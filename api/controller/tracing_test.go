@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vlegro/backend/api/repository"
+	"github.com/vlegro/backend/api/service"
+	"github.com/vlegro/backend/api/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// exporter collects the spans of all tests. The package tracers are bound to the first global
+// provider, so it is installed once and every test starts from an empty exporter.
+var (
+	exporter     = tracetest.NewInMemoryExporter()
+	installTrace sync.Once
+)
+
+// newTracedRouter serves the whole stack on a mocked database, spans end up in the returned exporter.
+func newTracedRouter(t *testing.T) (http.Handler, sqlmock.Sqlmock, *tracetest.InMemoryExporter) {
+	installTrace.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	exporter.Reset()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	customerService := service.NewCustomerService(repository.NewCustomerRepositoryImpl(db))
+	router := chi.NewRouter()
+	router.Use(tracing.Middleware)
+	router.Mount("/", NewCustomerController(customerService).RestController())
+	return router, mock, exporter
+}
+
+// spansByName indexes the finished spans, every name is expected once.
+func spansByName(t *testing.T, exporter *tracetest.InMemoryExporter) map[string]tracetest.SpanStub {
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		require.NotContains(t, spans, span.Name)
+		spans[span.Name] = span
+	}
+	return spans
+}
+
+func attributeValue(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracing_GetByPrefix(t *testing.T) {
+	router, mock, exporter := newTracedRouter(t)
+	columns := []string{"id", "first_name", "last_name", "patronymic_name", "phone", "email", "deleted_at"}
+	mock.ExpectQuery(`SELECT id, .* FROM customer WHERE`).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(1, "Клиент1", "Клиентов1", nil, nil, "test1@test.ru", nil).
+			AddRow(2, "Клиент2", "Клиентов2", nil, nil, "test2@test.ru", nil))
+
+	request := httptest.NewRequest(http.MethodGet, "/customers?prefix=Кл", nil)
+	request.Header.Set("traceparent", traceparent)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.NoError(t, mock.ExpectationsWereMet())
+	spans := spansByName(t, exporter)
+	require.Len(t, spans, 3)
+
+	// The server span continues the caller's trace
+	server := spans["GET /customers"]
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID().String())
+	assert.True(t, server.Parent.IsRemote())
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind)
+	assert.Equal(t, int64(200), attributeValue(server, "http.response.status_code").AsInt64())
+
+	serviceSpan := spans["CustomerService.Get"]
+	assert.Equal(t, server.SpanContext.SpanID(), serviceSpan.Parent.SpanID())
+	assert.Equal(t, int64(2), attributeValue(serviceSpan, "customers.count").AsInt64())
+
+	query := spans["SELECT customer"]
+	assert.Equal(t, serviceSpan.SpanContext.SpanID(), query.Parent.SpanID())
+	assert.Equal(t, trace.SpanKindClient, query.SpanKind)
+	assert.Equal(t, int64(2), attributeValue(query, "db.rows").AsInt64())
+	// The statement shape has placeholders, never the searched prefix
+	statement := attributeValue(query, "db.statement").AsString()
+	assert.Contains(t, statement, "LIKE $1")
+	assert.NotContains(t, statement, "Кл")
+}
+
+func TestTracing_DeleteByPrefix(t *testing.T) {
+	router, mock, exporter := newTracedRouter(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id, first_name, last_name, patronymic_name FROM customer .* FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "patronymic_name"}).
+			AddRow(1, "Клиент1", "Клиентов1", nil).
+			AddRow(2, "Клиент2", "Клиентов2", nil))
+	columns := []string{"id", "first_name", "last_name", "patronymic_name", "phone", "email", "deleted_at"}
+	mock.ExpectQuery(`UPDATE customer SET deleted_at = now\(\)`).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(2, "Клиент2", "Клиентов2", nil, nil, nil, time.Now()).
+			AddRow(1, "Клиент1", "Клиентов1", nil, nil, nil, time.Now()))
+	mock.ExpectExec(`INSERT INTO customer_audit`).
+		WithArgs("delete_by_prefix", service.AnonymousActor, "", `{"Кл"}`, "{}", "{1,2}", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/customers?prefix=Кл", nil))
+
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.NoError(t, mock.ExpectationsWereMet())
+	spans := spansByName(t, exporter)
+	require.Len(t, spans, 5)
+
+	server := spans["DELETE /customers"]
+	assert.False(t, server.Parent.IsValid())
+	serviceSpan := spans["CustomerService.Delete"]
+	assert.Equal(t, server.SpanContext.SpanID(), serviceSpan.Parent.SpanID())
+	for _, name := range []string{"SELECT customer", "UPDATE customer"} {
+		assert.Equal(t, serviceSpan.SpanContext.SpanID(), spans[name].Parent.SpanID(), name)
+		assert.Equal(t, int64(2), attributeValue(spans[name], "db.rows").AsInt64(), name)
+	}
+	// The audit entry is written in the same transaction, before the commit
+	assert.Equal(t, serviceSpan.SpanContext.SpanID(), spans["INSERT customer_audit"].Parent.SpanID())
+}
+
+func TestTracing_Error(t *testing.T) {
+	router, mock, exporter := newTracedRouter(t)
+	mock.ExpectQuery(`SELECT id, .* FROM customer WHERE id = \$1`).WillReturnError(errors.New("connection reset"))
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/customers/7", nil))
+
+	require.Equal(t, http.StatusInternalServerError, recorder.Code)
+	spans := spansByName(t, exporter)
+	for _, name := range []string{"GET /customers/{id}", "CustomerService.GetById", "SELECT customer"} {
+		assert.Equal(t, "Error", spans[name].Status.Code.String(), name)
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vlegro/backend/api/logging"
+	"github.com/vlegro/backend/api/service"
+)
+
+type AuditHandler struct {
+	auditService *service.AuditService
+}
+
+func NewAuditHandler(auditService *service.AuditService) *AuditHandler {
+	return &AuditHandler{auditService: auditService}
+}
+
+// HandleList serves GET /audit, filtered by the RFC 3339 from and to times, actor and customerId.
+func (ah *AuditHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	// Get filters from query parameters
+	var query service.AuditQuery
+	var ok bool
+	if query.From, ok = parseTime(w, r, "from"); !ok {
+		return
+	}
+	if query.To, ok = parseTime(w, r, "to"); !ok {
+		return
+	}
+	query.Actor = r.URL.Query().Get("actor")
+	if customerId := r.URL.Query().Get("customerId"); customerId != "" {
+		var err error
+		query.CustomerId, err = strconv.Atoi(customerId)
+		if err != nil || query.CustomerId <= 0 {
+			badRequest(w, r, "customerId", "customerId must be a positive number")
+			return
+		}
+	}
+
+	// Get pagination parameters
+	pageRequest, ok := parsePageRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Get audit entries
+	page, err := ah.auditService.List(r.Context(), query, pageRequest)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	logging.SetResult(r.Context(), slog.Int("count", len(page.Entries)))
+	writeJSON(w, r, http.StatusOK, page)
+}
+
+// parseTime reads an optional RFC 3339 query parameter, writing 400 when it is malformed.
+func parseTime(w http.ResponseWriter, r *http.Request, name string) (time.Time, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, true
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		badRequest(w, r, name, name+" must be an RFC 3339 time, e.g. 2024-01-31T15:04:05Z")
+		return time.Time{}, false
+	}
+	return parsed, true
+}
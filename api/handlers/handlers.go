@@ -1,14 +1,56 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/vlegro/backend/api/logging"
+	"github.com/vlegro/backend/api/middleware"
+	"github.com/vlegro/backend/api/problem"
+	"github.com/vlegro/backend/api/repository"
 	"github.com/vlegro/backend/api/service"
 )
 
+// codeConfirmationRequired is the problem code of a bulk delete above the limit.
+const codeConfirmationRequired = "confirmation_required"
+
+// statusClientClosedRequest is the nginx status of a request the client gave up on. Nobody
+// reads it, it keeps the access log and the metrics from counting the request as a success.
+const statusClientClosedRequest = 499
+
+// statusByCode maps service error codes to HTTP statuses.
+var statusByCode = map[service.Code]int{
+	service.CodeValidation:    http.StatusBadRequest,
+	service.CodeInvalidFields: http.StatusUnprocessableEntity,
+	service.CodeForbidden:     http.StatusForbidden,
+	service.CodeNotFound:      http.StatusNotFound,
+	service.CodeConflict:      http.StatusConflict,
+	service.CodeTimeout:       http.StatusGatewayTimeout,
+	service.CodeInternal:      http.StatusInternalServerError,
+}
+
+// confirmationRequired is the 428 response of a bulk delete above the limit,
+// the client repeats the request with ?confirm=<confirmToken> to proceed.
+type confirmationRequired struct {
+	problem.Problem
+	Count        int    `json:"count"`
+	Ids          []int  `json:"ids"`
+	ConfirmToken string `json:"confirmToken"`
+}
+
+// invalidFields is the 422 response to a customer with invalid fields, listing all of them.
+type invalidFields struct {
+	problem.Problem
+	Errors []service.FieldError `json:"errors"`
+}
+
 type CustomerHandler struct {
 	customerService *service.CustomerService
 }
@@ -20,69 +62,388 @@ func NewCustomerHandler(customerService *service.CustomerService) *CustomerHandl
 func (ch *CustomerHandler) HandleDeleteByPrefix(w http.ResponseWriter, r *http.Request) {
 	// Only allow DELETE method
 	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		MethodNotAllowed(w, r)
 		return
 	}
 
-	// Get prefix from query parameter
-	prefix := r.URL.Query().Get("prefix")
-	if prefix == "" {
-		http.Error(w, "prefix parameter is required", http.StatusBadRequest)
+	// Get prefixes and fields from query parameters
+	filter, ok := parsePrefixFilter(w, r)
+	if !ok {
+		return
+	}
+
+	// A dry run only previews the customers that would be deleted,
+	// confirm carries the token for deletes above the limit
+	deleteRequest := service.DeleteRequest{ConfirmToken: r.URL.Query().Get("confirm")}
+	if deleteRequest.DryRun, ok = parseBool(w, r, "dryRun"); !ok {
 		return
 	}
 
 	// Delete customers
-	deleteInfo, err := ch.customerService.Delete(prefix)
+	deleteInfo, err := ch.customerService.Delete(r.Context(), filter, deleteRequest)
 	if err != nil {
-		log.Printf("Error deleting customers: %v", err)
-		http.Error(w, "Failed to delete customers", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
+	logging.SetResult(r.Context(), slog.Int("count", deleteInfo.Count), slog.Bool("dry_run", deleteInfo.DryRun))
+
 	// Set response headers
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
 	// Write response
 	if err := json.NewEncoder(w).Encode(deleteInfo); err != nil {
-		log.Printf("Error encoding response: %v", err)
+		logging.FromContext(r.Context()).Warn("Error encoding response", "error", err)
 	}
 }
 
 func (ch *CustomerHandler) HandleGetByPrefix(w http.ResponseWriter, r *http.Request) {
 	// Only allow GET method
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		MethodNotAllowed(w, r)
 		return
 	}
 
-	// Get prefix from query parameter
-	prefix := r.URL.Query().Get("prefix")
-	if prefix == "" {
-		http.Error(w, "prefix parameter is required", http.StatusBadRequest)
+	// Get prefixes and fields from query parameters
+	filter, ok := parsePrefixFilter(w, r)
+	if !ok {
 		return
 	}
 
-	// Split prefix by comma and trim spaces
-	prefixes := strings.Split(prefix, ",")
-	for i := range prefixes {
-		prefixes[i] = strings.TrimSpace(prefixes[i])
+	// Get pagination parameters
+	pageRequest, ok := parsePageRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Deleted customers are only listed on request
+	if filter.IncludeDeleted, ok = parseBool(w, r, "includeDeleted"); !ok {
+		return
 	}
 
 	// Get customers
-	customers, err := ch.customerService.Get(prefixes)
+	page, err := ch.customerService.Get(r.Context(), filter, pageRequest)
 	if err != nil {
-		log.Printf("Error getting customers: %v", err)
-		http.Error(w, "Failed to get customers", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
+	logging.SetResult(r.Context(), slog.Int("count", len(page.Customers)))
+
 	// Set response headers
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
 	// Write response
-	if err := json.NewEncoder(w).Encode(customers); err != nil {
-		log.Printf("Error encoding response: %v", err)
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		logging.FromContext(r.Context()).Warn("Error encoding response", "error", err)
+	}
+}
+
+func (ch *CustomerHandler) HandleCreate(w http.ResponseWriter, r *http.Request) {
+	customer, ok := decodeCustomer(w, r)
+	if !ok {
+		return
+	}
+
+	// Create customer
+	created, err := ch.customerService.Create(r.Context(), customer)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/customers/%d", created.Id))
+	writeJSON(w, r, http.StatusCreated, created)
+}
+
+func (ch *CustomerHandler) HandleGetById(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseId(w, r)
+	if !ok {
+		return
+	}
+
+	// Get customer
+	customer, err := ch.customerService.GetById(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, customer)
+}
+
+func (ch *CustomerHandler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseId(w, r)
+	if !ok {
+		return
+	}
+	customer, ok := decodeCustomer(w, r)
+	if !ok {
+		return
+	}
+
+	// Replace customer
+	updated, err := ch.customerService.Update(r.Context(), id, customer)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
-}
\ No newline at end of file
+
+	writeJSON(w, r, http.StatusOK, updated)
+}
+
+func (ch *CustomerHandler) HandlePatch(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseId(w, r)
+	if !ok {
+		return
+	}
+	patch, ok := decodeCustomer(w, r)
+	if !ok {
+		return
+	}
+
+	// Update provided fields
+	patched, err := ch.customerService.Patch(r.Context(), id, patch)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, patched)
+}
+
+func (ch *CustomerHandler) HandleDeleteById(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseId(w, r)
+	if !ok {
+		return
+	}
+
+	// Delete customer
+	if err := ch.customerService.DeleteById(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parsePrefixFilter reads the comma separated prefix and field query parameters, the match mode
+// and the pattern flag, writing 400 when any of them is missing or invalid.
+func parsePrefixFilter(w http.ResponseWriter, r *http.Request) (repository.PrefixFilter, bool) {
+	prefix := r.URL.Query().Get("prefix")
+	if prefix == "" {
+		badRequest(w, r, "prefix", "prefix parameter is required")
+		return repository.PrefixFilter{}, false
+	}
+	filter := repository.PrefixFilter{Prefixes: splitList(prefix)}
+
+	if fields := r.URL.Query().Get("field"); fields != "" {
+		for _, name := range splitList(fields) {
+			field, err := repository.ParseField(name)
+			if err != nil {
+				badRequest(w, r, "field", err.Error())
+				return repository.PrefixFilter{}, false
+			}
+			filter.Fields = append(filter.Fields, field)
+		}
+	}
+
+	if match := r.URL.Query().Get("match"); match != "" {
+		mode, err := repository.ParseMatchMode(match)
+		if err != nil {
+			badRequest(w, r, "match", err.Error())
+			return repository.PrefixFilter{}, false
+		}
+		filter.Mode = mode
+	}
+
+	var ok bool
+	if filter.Pattern, ok = parseBool(w, r, "pattern"); !ok {
+		return repository.PrefixFilter{}, false
+	}
+
+	return filter, true
+}
+
+// parsePageRequest reads the limit and cursor query parameters, writing 400 for an invalid limit.
+func parsePageRequest(w http.ResponseWriter, r *http.Request) (service.PageRequest, bool) {
+	pageRequest := service.PageRequest{Cursor: r.URL.Query().Get("cursor")}
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		var err error
+		pageRequest.Limit, err = strconv.Atoi(limit)
+		if err != nil || pageRequest.Limit <= 0 {
+			badRequest(w, r, "limit", "limit must be a positive number")
+			return service.PageRequest{}, false
+		}
+	}
+	return pageRequest, true
+}
+
+// parseBool reads an optional boolean query parameter, writing 400 when it is malformed.
+func parseBool(w http.ResponseWriter, r *http.Request, name string) (bool, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false, true
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		badRequest(w, r, name, fmt.Sprintf("%s must be a boolean", name))
+		return false, false
+	}
+	return parsed, true
+}
+
+// splitList splits a comma separated query parameter and trims spaces.
+func splitList(value string) []string {
+	items := strings.Split(value, ",")
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+	return items
+}
+
+// restoreRequest is the body of POST /customers/restore when no prefix is given.
+type restoreRequest struct {
+	Ids []int `json:"ids"`
+}
+
+// HandleRestore brings back deleted customers, either the ones matching the same prefix query
+// parameters as DELETE /customers or the ids from the JSON body.
+func (ch *CustomerHandler) HandleRestore(w http.ResponseWriter, r *http.Request) {
+	var restoreInfo repository.DeleteInfo
+	var err error
+
+	if r.URL.Query().Get("prefix") != "" {
+		filter, ok := parsePrefixFilter(w, r)
+		if !ok {
+			return
+		}
+		restoreInfo, err = ch.customerService.RestoreByPrefix(r.Context(), filter)
+	} else {
+		var request restoreRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			invalidBody(w, r, err)
+			return
+		}
+		if len(request.Ids) == 0 {
+			badRequest(w, r, "ids", "prefix parameter or ids are required")
+			return
+		}
+		restoreInfo, err = ch.customerService.RestoreByIds(r.Context(), request.Ids)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	logging.SetResult(r.Context(), slog.Int("count", restoreInfo.Count))
+	writeJSON(w, r, http.StatusOK, restoreInfo)
+}
+
+// parseId reads the {id} URL parameter, writing 400 when it is not a positive number.
+func parseId(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		badRequest(w, r, "id", "id must be a positive number")
+		return 0, false
+	}
+	return id, true
+}
+
+// decodeCustomer reads a customer from the JSON request body, writing 400 on malformed input
+// and 413 on a body over the size limit.
+func decodeCustomer(w http.ResponseWriter, r *http.Request) (repository.CustomerInfo, bool) {
+	var customer repository.CustomerInfo
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&customer); err != nil {
+		invalidBody(w, r, err)
+		return repository.CustomerInfo{}, false
+	}
+	return customer, true
+}
+
+// invalidBody answers a request body that could not be decoded, with 413 when it was cut off
+// by the size limit.
+func invalidBody(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		middleware.TooLarge(w, r, tooLarge.Limit)
+		return
+	}
+	badRequest(w, r, "body", fmt.Sprintf("invalid request body: %v", err))
+}
+
+// writeError answers with the problem matching err. An expired request deadline is a 504
+// and only the status 499 is written when the client went away, unexpected errors are logged.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	// The driver reports cancelled queries with its own errors, the request context tells why
+	ctxErr := r.Context().Err()
+	if errors.Is(ctxErr, context.Canceled) {
+		logging.FromContext(r.Context()).Info("Request cancelled by client", "error", err)
+		w.WriteHeader(statusClientClosedRequest)
+		return
+	}
+	if errors.Is(ctxErr, context.DeadlineExceeded) {
+		err = &service.Error{Code: service.CodeTimeout, Message: "request timed out", Err: err}
+	}
+
+	var confirmationErr *service.ConfirmationRequiredError
+	if errors.As(err, &confirmationErr) {
+		body := confirmationRequired{
+			Problem:      *problem.New(r, http.StatusPreconditionRequired, codeConfirmationRequired, confirmationErr.Error()),
+			Count:        confirmationErr.Count,
+			Ids:          confirmationErr.Ids,
+			ConfirmToken: confirmationErr.Token,
+		}
+		problem.WriteBody(w, r, http.StatusPreconditionRequired, body)
+		return
+	}
+
+	code := service.ErrorCode(err)
+	status := statusByCode[code]
+	message := "internal error"
+	var serviceErr *service.Error
+	if errors.As(err, &serviceErr) && serviceErr.Message != "" {
+		message = serviceErr.Message
+	}
+	if status >= http.StatusInternalServerError {
+		logging.FromContext(r.Context()).Error("Error handling request", "code", code, "error", err)
+	}
+
+	p := problem.New(r, status, string(code), message)
+	if serviceErr != nil && len(serviceErr.Fields) > 0 {
+		problem.WriteBody(w, r, status, invalidFields{Problem: *p, Errors: serviceErr.Fields})
+		return
+	}
+	if serviceErr != nil {
+		p.ForField(serviceErr.Field)
+	}
+	p.Write(w, r)
+}
+
+// badRequest reports invalid input found before the service is called.
+func badRequest(w http.ResponseWriter, r *http.Request, field, message string) {
+	problem.New(r, http.StatusBadRequest, string(service.CodeValidation), message).ForField(field).Write(w, r)
+}
+
+// NotFound answers requests to unknown routes.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	problem.New(r, http.StatusNotFound, "route_not_found", "no such route").Write(w, r)
+}
+
+// MethodNotAllowed answers requests with a method the route does not support.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	problem.New(r, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed").Write(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.FromContext(r.Context()).Warn("Error encoding response", "error", err)
+	}
+}
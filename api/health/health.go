@@ -0,0 +1,104 @@
+// Package health serves the liveness and readiness probes.
+package health
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vlegro/backend/api/logging"
+)
+
+// DefaultTimeout bounds all readiness checks together.
+const DefaultTimeout = 2 * time.Second
+
+// Check reports whether one dependency is usable, a nil error means it is.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// CheckResult is the outcome of one Check in the readiness response.
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report is the body of both probes.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+const (
+	statusOk          = "ok"
+	statusUnavailable = "unavailable"
+	statusFailed      = "failed"
+)
+
+// Liveness answers 200 as long as the process serves requests, it checks no dependency
+// so that a database outage does not get the instance restarted.
+func Liveness(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, r, http.StatusOK, Report{Status: statusOk})
+}
+
+// Readiness runs every check within timeout and answers 503 unless all of them pass.
+func Readiness(timeout time.Duration, checks ...Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		report := Report{Status: statusOk, Checks: make(map[string]CheckResult, len(checks))}
+		status := http.StatusOK
+		for _, check := range checks {
+			if err := check.Run(ctx); err != nil {
+				report.Status = statusUnavailable
+				status = http.StatusServiceUnavailable
+				report.Checks[check.Name] = CheckResult{Status: statusFailed, Error: err.Error()}
+				continue
+			}
+			report.Checks[check.Name] = CheckResult{Status: statusOk}
+		}
+
+		writeReport(w, r, status, report)
+	}
+}
+
+// Database pings db.
+func Database(db *sql.DB) Check {
+	return Check{
+		Name: "database",
+		Run:  db.PingContext,
+	}
+}
+
+// Migrations checks that the gormigrate table contains latestId, i.e. that the schema is current.
+func Migrations(db *sql.DB, latestId string) Check {
+	return Check{
+		Name: "migrations",
+		Run: func(ctx context.Context) error {
+			var applied bool
+			err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM migrations WHERE id = $1)`, latestId).Scan(&applied)
+			if err != nil {
+				return fmt.Errorf("could not read migrations: %w", err)
+			}
+			if !applied {
+				return fmt.Errorf("schema is behind, migration %s is not applied", latestId)
+			}
+			return nil
+		},
+	}
+}
+
+func writeReport(w http.ResponseWriter, r *http.Request, status int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logging.FromContext(r.Context()).Warn("Error encoding health report", "error", err)
+	}
+}
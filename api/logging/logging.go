@@ -0,0 +1,50 @@
+// Package logging sets up structured JSON logging and carries a request scoped logger in the context.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIdKey
+	resultKey
+)
+
+// New returns a JSON logger writing records at level and above to w, with personal data redacted.
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	}))
+}
+
+// WithLogger returns a copy of ctx carrying logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// FromContext returns the logger of ctx, or slog.Default outside of a request.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// result collects the attributes a handler adds to its access log line.
+type result struct {
+	attrs []slog.Attr
+}
+
+// SetResult adds attrs, such as the number of customers returned, to the access log line of
+// the request. It does nothing outside of AccessLog.
+func SetResult(ctx context.Context, attrs ...slog.Attr) {
+	if r, ok := ctx.Value(resultKey).(*result); ok {
+		r.attrs = append(r.attrs, attrs...)
+	}
+}
@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"prefix test1@test.ru not found", "prefix [REDACTED] not found"},
+		{"клиент@почта.рф", "[REDACTED]"},
+		{"phone 77777777777", "phone [REDACTED]"},
+		{"call +7 (999) 123-45-67 now", "call [REDACTED] now"},
+		{"deleted 3 customers in 120ms", "deleted 3 customers in 120ms"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Redact(tt.input))
+		})
+	}
+}
+
+func TestNew_RedactsPersonalData(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelInfo)
+
+	logger.Info("Lookup by test1@test.ru",
+		"email", "anything",
+		"phone", "+77777777777",
+		"error", errors.New("duplicate key test2@test.ru"),
+		"request_id", "12345678901234567890",
+		"count", 3,
+	)
+	logger.Debug("below the level")
+
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "Lookup by [REDACTED]", record["msg"])
+	assert.Equal(t, "[REDACTED]", record["email"])
+	assert.Equal(t, "[REDACTED]", record["phone"])
+	assert.Equal(t, "duplicate key [REDACTED]", record["error"])
+	assert.Equal(t, "12345678901234567890", record["request_id"])
+	assert.Equal(t, 3.0, record["count"])
+	assert.NotContains(t, buf.String(), "below the level")
+}
+
+func TestMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelInfo)
+
+	router := chi.NewRouter()
+	router.Use(RequestID, AccessLog(logger))
+	router.Get("/customers/{id}", func(w http.ResponseWriter, r *http.Request) {
+		assert.NotEmpty(t, RequestId(r.Context()))
+		FromContext(r.Context()).Info("inside handler")
+		SetResult(r.Context(), slog.Int("count", 2))
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	// A valid id of the caller is propagated
+	request := httptest.NewRequest(http.MethodGet, "/customers/7", nil)
+	request.Header.Set(RequestIdHeader, "caller-id.1")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(t, "caller-id.1", recorder.Header().Get(RequestIdHeader))
+
+	decoder := json.NewDecoder(&buf)
+	var handlerLine, accessLine map[string]interface{}
+	require.NoError(t, decoder.Decode(&handlerLine))
+	require.NoError(t, decoder.Decode(&accessLine))
+	assert.Equal(t, "caller-id.1", handlerLine["request_id"])
+	assert.Equal(t, "caller-id.1", accessLine["request_id"])
+	assert.Equal(t, "GET", accessLine["method"])
+	assert.Equal(t, "/customers/{id}", accessLine["route"])
+	assert.Equal(t, 202.0, accessLine["status"])
+	assert.Equal(t, 2.0, accessLine["count"])
+	assert.Contains(t, accessLine, "duration_ms")
+
+	// An invalid one is replaced
+	request = httptest.NewRequest(http.MethodGet, "/customers/7", nil)
+	request.Header.Set(RequestIdHeader, "bad id\nwith newline")
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Regexp(t, "^[0-9a-f]{32}$", recorder.Header().Get(RequestIdHeader))
+}
@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// RequestIdHeader carries the request id in both directions.
+const RequestIdHeader = "X-Request-ID"
+
+// requestIdAttr is the attribute of the request id in every log record of a request.
+const requestIdAttr = "request_id"
+
+// validRequestId limits propagated ids to what is safe to log and echo back.
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._\-]{1,64}$`)
+
+// RequestID propagates a valid X-Request-ID of the caller or assigns a new one,
+// and returns it in the response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIdHeader)
+		if !validRequestId.MatchString(id) {
+			id = newRequestId()
+		}
+		w.Header().Set(RequestIdHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIdKey, id)))
+	})
+}
+
+// RequestId returns the id assigned by RequestID, or "" outside of a request.
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey).(string)
+	return id
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// AccessLog puts logger, tagged with the request id, in the request context and writes one
+// line per request with its method, route, status, duration and the attributes given to
+// SetResult. Use it on the top-level router after RequestID.
+func AccessLog(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			requestLogger := logger.With(slog.String(requestIdAttr, RequestId(r.Context())))
+			res := &result{}
+			ctx := context.WithValue(WithLogger(r.Context(), requestLogger), resultKey, res)
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			level := slog.LevelInfo
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+
+			attrs := append([]slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("route", routePattern(r)),
+				slog.Int("status", status),
+				slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.Int("bytes", ww.BytesWritten()),
+			}, res.attrs...)
+			requestLogger.LogAttrs(ctx, level, "request", attrs...)
+		})
+	}
+}
+
+// routePattern is the matched route, e.g. /customers/{id}. The raw path is not logged,
+// it is unbounded and may carry personal data.
+func routePattern(r *http.Request) string {
+	if routeContext := chi.RouteContext(r.Context()); routeContext != nil {
+		if pattern := routeContext.RoutePattern(); pattern != "" && pattern != "/*" {
+			return pattern
+		}
+	}
+	return "unmatched"
+}
@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"log/slog"
+	"regexp"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attributes that hold personal data as a whole.
+var sensitiveKeys = map[string]bool{
+	"email": true,
+	"phone": true,
+}
+
+// Personal data may also hide inside messages and errors, e.g. a prefix that is part of an email.
+var (
+	emailPattern = regexp.MustCompile(`[\p{L}\p{N}._%+\-]+@[\p{L}\p{N}.\-]+`)
+	// Seven or more digits, optionally separated the way phone numbers are written
+	phonePattern = regexp.MustCompile(`\+?\d(?:[\s()\-]*\d){6,}`)
+)
+
+// Redact masks email addresses and phone numbers in s.
+func Redact(s string) string {
+	s = emailPattern.ReplaceAllString(s, redacted)
+	return phonePattern.ReplaceAllString(s, redacted)
+}
+
+// redactAttr is the slog.HandlerOptions.ReplaceAttr of New. Request ids are left alone,
+// they may contain long runs of digits.
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[a.Key] {
+		return slog.String(a.Key, redacted)
+	}
+	if a.Key == requestIdAttr {
+		return a
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, Redact(err.Error()))
+		}
+	}
+	return a
+}
@@ -1,50 +1,188 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
 	_ "github.com/lib/pq" // postgres driver
+	"github.com/vlegro/backend/api/auth"
 	"github.com/vlegro/backend/api/controller"
+	"github.com/vlegro/backend/api/health"
+	"github.com/vlegro/backend/api/logging"
+	"github.com/vlegro/backend/api/metrics"
+	"github.com/vlegro/backend/api/middleware"
+	"github.com/vlegro/backend/api/ratelimit"
 	"github.com/vlegro/backend/api/repository"
 	"github.com/vlegro/backend/api/service"
-
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
+	"github.com/vlegro/backend/api/tracing"
+	"github.com/vlegro/backend/config"
+	"github.com/vlegro/backend/database"
+	"github.com/vlegro/backend/migrations"
 )
 
 func main() {
-	servicePort := "3322"
-	log.Printf("REST API started at %s...\n", servicePort)
-	dbConnectionUrl, exists := os.LookupEnv("DB_CONNECTION_URL")
-	if !exists {
-		log.Fatal("DB_CONNECTION_URL env variable does not exist")
+	cfg, args, err := config.Load(os.Args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
+	if len(args) > 0 {
+		log.Fatalf("unexpected arguments: %v", args)
+	}
+
+	if err := run(cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves the API until SIGINT or SIGTERM, then drains in-flight requests and closes
+// the DB pool. It returns instead of exiting so that the deferred cleanup always runs.
+func run(cfg config.Config) error {
+	level, _ := cfg.Log.SlogLevel()
+	logger := logging.New(os.Stdout, level)
+	// Also routes the log package, used by libraries, through the JSON handler
+	slog.SetDefault(logger)
+
+	// SIGTERM also aborts waiting for the database
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	db, err := gorm.Open(postgres.Open(dbConnectionUrl))
-	failOnError(err, "Could not open DB connection")
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Flush the spans of the last requests, the signal context is already done here
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			slog.Error("Error flushing spans", "error", err)
+		}
+	}()
+
+	db, err := database.Open(ctx, cfg.DB)
+	if err != nil {
+		return err
+	}
 	dbConnection, err := db.DB()
-	failOnError(err, "Could not get sql connection")
+	if err != nil {
+		return fmt.Errorf("could not get sql connection: %w", err)
+	}
+	defer func() {
+		if err := dbConnection.Close(); err != nil {
+			slog.Error("Error closing DB connection", "error", err)
+		}
+		slog.Info("DB connection closed")
+	}()
+	apiMetrics := metrics.New()
+	apiMetrics.RegisterDB("postgres", dbConnection)
 
-	defer dbConnection.Close()
+	authenticator, err := auth.New(cfg.Auth)
+	if err != nil {
+		return err
+	}
+	policy, err := auth.PolicyFrom(cfg.Auth)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !cfg.Auth.Required && len(cfg.Auth.AnonymousRoles) > 0:
+		slog.Warn("Requests without credentials are granted roles", "anonymous_roles", cfg.Auth.AnonymousRoles)
+	case len(cfg.Auth.APIKeys) == 0 && cfg.Auth.JWT.Secret == "" && cfg.Auth.JWT.JWKSFile == "":
+		slog.Warn("No credentials are configured and anonymous callers have no roles, every API request is forbidden")
+	}
+
+	customerService := dependencyInjection(dbConnection, apiMetrics, serviceOptions(cfg, policy))
+	auditService := service.NewAuditService(repository.NewAuditRepositoryImpl(dbConnection), policy,
+		service.WithAuditPageSizes(cfg.Customers.DefaultPageSize, cfg.Customers.MaxPageSize))
+	controllerOptions := []controller.Option{
+		controller.WithAuthenticator(authenticator),
+		controller.WithTimeouts(controller.NewTimeouts(cfg.HTTP.Timeouts)),
+		controller.WithRestore(cfg.Features.Restore),
+		controller.WithAudit(auditService),
+	}
+	if cfg.RateLimit.Enabled {
+		controllerOptions = append(controllerOptions, controller.WithRateLimit(ratelimit.NewMemoryStore(), controller.NewLimits(cfg.RateLimit.Limits)))
+	}
+	customerController := controller.NewCustomerController(customerService, controllerOptions...)
+	router := chi.NewRouter()
+	if cfg.RateLimit.TrustForwardedFor {
+		router.Use(chimiddleware.RealIP)
+	}
+	router.Use(logging.RequestID, tracing.Middleware, logging.AccessLog(logger), apiMetrics.Middleware)
+	router.Use(middleware.Stack(cfg.HTTP)...)
+	router.Get("/healthz", health.Liveness)
+	router.Get("/readyz", health.Readiness(health.DefaultTimeout,
+		health.Database(dbConnection),
+		health.Migrations(dbConnection, migrations.LatestId()),
+	))
+	router.Handle("/metrics", apiMetrics.Handler())
+	router.Mount("/", customerController.RestController())
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%d", cfg.HTTP.Port),
+		Handler:      router,
+		ReadTimeout:  time.Duration(cfg.HTTP.ReadTimeout),
+		WriteTimeout: time.Duration(cfg.HTTP.WriteTimeout),
+		IdleTimeout:  time.Duration(cfg.HTTP.IdleTimeout),
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("REST API started", "port", cfg.HTTP.Port)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		return fmt.Errorf("server failed: %w", err)
+	case <-ctx.Done():
+	}
+	// A second signal kills the process right away
+	stop()
 
-	customerService := dependencyInjection(dbConnection)
-	customerController := controller.NewCustomerController(customerService)
-	err = http.ListenAndServe(fmt.Sprintf(":%s", servicePort), customerController.RestController())
-	log.Fatal(err)
+	gracePeriod := time.Duration(cfg.HTTP.ShutdownGracePeriod)
+	slog.Info("Shutting down, draining in-flight requests", "grace_period", gracePeriod)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		// Drop the requests that did not finish in time before the DB pool goes away
+		_ = server.Close()
+		return fmt.Errorf("graceful shutdown failed: %w", err)
+	}
+	slog.Info("REST API stopped")
+
+	return nil
 }
 
-func dependencyInjection(dbConnection *sql.DB) *service.CustomerService {
-	customerRepository := repository.NewCustomerRepositoryImpl(dbConnection)
-	customerService := service.NewCustomerService(customerRepository)
+func dependencyInjection(dbConnection *sql.DB, apiMetrics *metrics.Metrics, options []service.Option) *service.CustomerService {
+	customerRepository := apiMetrics.InstrumentRepository(repository.NewCustomerRepositoryImpl(dbConnection))
+	customerService := service.NewCustomerService(customerRepository, options...)
 	return customerService
 }
 
-func failOnError(err error, msg string) {
-	if err != nil {
-		log.Fatalf("%s: %s", msg, err)
+func serviceOptions(cfg config.Config, policy *auth.Policy) []service.Option {
+	options := []service.Option{
+		service.WithPolicy(policy),
+		service.WithMaxDeleteCount(cfg.Customers.MaxDeleteCount),
+		service.WithPageSizes(cfg.Customers.DefaultPageSize, cfg.Customers.MaxPageSize),
+		service.WithPatternSearch(cfg.Features.PatternSearch),
+	}
+	if cfg.Customers.DeleteConfirmationSecret != "" {
+		options = append(options, service.WithConfirmationSecret([]byte(cfg.Customers.DeleteConfirmationSecret)))
 	}
+	return options
 }
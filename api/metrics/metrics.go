@@ -0,0 +1,85 @@
+// Package metrics exposes Prometheus metrics of the HTTP, service and repository layers.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "backend"
+
+// Metrics owns a registry of its own, so tests can create as many as they like.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests        *prometheus.CounterVec
+	httpRequestDuration *prometheus.HistogramVec
+	queryDuration       *prometheus.HistogramVec
+	customersReturned   prometheus.Counter
+	customersDeleted    prometheus.Counter
+	customersRestored   prometheus.Counter
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by method, route and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "repository_query_duration_seconds",
+			Help:      "Customer repository call latency by operation and result.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation", "result"}),
+		customersReturned: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "customers_returned_total",
+			Help:      "Customers returned to prefix queries.",
+		}),
+		customersDeleted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "customers_deleted_total",
+			Help:      "Customers deleted, dry runs excluded.",
+		}),
+		customersRestored: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "customers_restored_total",
+			Help:      "Deleted customers brought back.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.httpRequests,
+		m.httpRequestDuration,
+		m.queryDuration,
+		m.customersReturned,
+		m.customersDeleted,
+		m.customersRestored,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// RegisterDB adds the gauges and counters of the db pool, see sql.DBStats.
+func (m *Metrics) RegisterDB(name string, db *sql.DB) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the registry in the Prometheus exposition format, mount it at /metrics.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
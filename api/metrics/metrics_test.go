@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vlegro/backend/api/repository"
+)
+
+func TestMiddleware(t *testing.T) {
+	m := New()
+	customers := chi.NewRouter()
+	customers.Get("/customers/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	customers.Get("/customers", func(w http.ResponseWriter, r *http.Request) {})
+	router := chi.NewRouter()
+	router.Use(m.Middleware)
+	router.Mount("/", customers)
+
+	for _, path := range []string{"/customers/1", "/customers/2", "/customers", "/unknown"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.httpRequests.WithLabelValues("GET", "/customers/{id}", "404")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.httpRequests.WithLabelValues("GET", "/customers", "200")))
+	// Unknown paths share one series instead of one per path
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.httpRequests.WithLabelValues("GET", unmatchedRoute, "404")))
+	assert.Equal(t, 3, testutil.CollectAndCount(m.httpRequestDuration))
+}
+
+// fakeRepository implements the calls the test needs, the others panic.
+type fakeRepository struct {
+	repository.CustomerRepository
+	err error
+}
+
+func (f *fakeRepository) GetByPrefix(ctx context.Context, filter repository.PrefixFilter, page repository.Page) ([]repository.CustomerInfo, error) {
+	return []repository.CustomerInfo{{Id: 1}, {Id: 2}}, f.err
+}
+
+func (f *fakeRepository) DeleteByPrefix(ctx context.Context, filter repository.PrefixFilter, options repository.DeleteOptions) (repository.DeleteInfo, error) {
+	return repository.DeleteInfo{Count: 3, Ids: []int{1, 2, 3}}, f.err
+}
+
+func TestInstrumentRepository(t *testing.T) {
+	m := New()
+	fake := &fakeRepository{}
+	repo := m.InstrumentRepository(fake)
+	ctx := context.Background()
+
+	customers, err := repo.GetByPrefix(ctx, repository.PrefixFilter{}, repository.Page{Limit: 3})
+	require.NoError(t, err)
+	assert.Len(t, customers, 2)
+	_, err = repo.GetByPrefix(ctx, repository.PrefixFilter{}, repository.Page{Limit: 2})
+	require.NoError(t, err)
+	_, err = repo.DeleteByPrefix(ctx, repository.PrefixFilter{}, repository.DeleteOptions{DryRun: true})
+	require.NoError(t, err)
+	_, err = repo.DeleteByPrefix(ctx, repository.PrefixFilter{}, repository.DeleteOptions{})
+	require.NoError(t, err)
+	fake.err = errors.New("connection reset")
+	_, err = repo.DeleteByPrefix(ctx, repository.PrefixFilter{}, repository.DeleteOptions{})
+	assert.Equal(t, fake.err, err)
+	_, err = repo.GetByPrefix(ctx, repository.PrefixFilter{}, repository.Page{Limit: 3})
+	assert.Equal(t, fake.err, err)
+
+	// Both rows of the first page, the second row of the full page was read ahead
+	assert.Equal(t, 3.0, testutil.ToFloat64(m.customersReturned))
+	// Neither the dry run nor the failed delete count
+	assert.Equal(t, 3.0, testutil.ToFloat64(m.customersDeleted))
+
+	// get_by_prefix/ok, get_by_prefix/error, delete_by_prefix/ok and delete_by_prefix/error
+	assert.Equal(t, 4, testutil.CollectAndCount(m.queryDuration))
+}
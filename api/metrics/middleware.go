@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// unmatchedRoute labels requests that matched no route, the raw path would make
+// the number of series unbounded. Such requests end at the "/*" of a router mounted at "/".
+const unmatchedRoute = "unmatched"
+
+// Middleware counts and times every request by its route pattern, e.g. /customers/{id}.
+// Use it on the top-level router, the pattern is complete once routing is done.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := unmatchedRoute
+		if routeContext := chi.RouteContext(r.Context()); routeContext != nil {
+			if pattern := routeContext.RoutePattern(); pattern != "" && pattern != "/*" {
+				route = pattern
+			}
+		}
+		status := ww.Status()
+		if status == 0 {
+			// Nothing was written, net/http answers 200
+			status = http.StatusOK
+		}
+
+		labels := []string{r.Method, route, strconv.Itoa(status)}
+		m.httpRequests.WithLabelValues(labels...).Inc()
+		m.httpRequestDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/vlegro/backend/api/repository"
+)
+
+// instrumentedRepository times every call of the wrapped repository and counts
+// the customers it returns, deletes and restores.
+type instrumentedRepository struct {
+	next    repository.CustomerRepository
+	metrics *Metrics
+}
+
+// InstrumentRepository decorates repo with metrics, the result behaves exactly like repo.
+func (m *Metrics) InstrumentRepository(repo repository.CustomerRepository) repository.CustomerRepository {
+	return &instrumentedRepository{next: repo, metrics: m}
+}
+
+func (ir *instrumentedRepository) observe(operation string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	ir.metrics.queryDuration.WithLabelValues(operation, result).Observe(time.Since(start).Seconds())
+}
+
+func (ir *instrumentedRepository) Create(ctx context.Context, customer repository.CustomerInfo) (repository.CustomerInfo, error) {
+	start := time.Now()
+	created, err := ir.next.Create(ctx, customer)
+	ir.observe("create", start, err)
+	return created, err
+}
+
+func (ir *instrumentedRepository) GetById(ctx context.Context, id int) (repository.CustomerInfo, error) {
+	start := time.Now()
+	customer, err := ir.next.GetById(ctx, id)
+	ir.observe("get_by_id", start, err)
+	return customer, err
+}
+
+func (ir *instrumentedRepository) Update(ctx context.Context, customer repository.CustomerInfo, options repository.WriteOptions) (repository.CustomerInfo, error) {
+	start := time.Now()
+	updated, err := ir.next.Update(ctx, customer, options)
+	ir.observe("update", start, err)
+	return updated, err
+}
+
+func (ir *instrumentedRepository) Patch(ctx context.Context, id int, patch repository.CustomerInfo, options repository.WriteOptions) (repository.CustomerInfo, error) {
+	start := time.Now()
+	patched, err := ir.next.Patch(ctx, id, patch, options)
+	ir.observe("patch", start, err)
+	return patched, err
+}
+
+func (ir *instrumentedRepository) DeleteById(ctx context.Context, id int, audit repository.AuditInfo) error {
+	start := time.Now()
+	err := ir.next.DeleteById(ctx, id, audit)
+	ir.observe("delete_by_id", start, err)
+	if err == nil {
+		ir.metrics.customersDeleted.Inc()
+	}
+	return err
+}
+
+func (ir *instrumentedRepository) DeleteByPrefix(ctx context.Context, filter repository.PrefixFilter, options repository.DeleteOptions) (repository.DeleteInfo, error) {
+	start := time.Now()
+	deleteInfo, err := ir.next.DeleteByPrefix(ctx, filter, options)
+	ir.observe("delete_by_prefix", start, err)
+	if err == nil && !options.DryRun {
+		ir.metrics.customersDeleted.Add(float64(deleteInfo.Count))
+	}
+	return deleteInfo, err
+}
+
+func (ir *instrumentedRepository) GetByPrefix(ctx context.Context, filter repository.PrefixFilter, page repository.Page) ([]repository.CustomerInfo, error) {
+	start := time.Now()
+	customers, err := ir.next.GetByPrefix(ctx, filter, page)
+	ir.observe("get_by_prefix", start, err)
+	if err == nil {
+		// A full page holds the row read ahead to find the next page, clients never see it
+		count := len(customers)
+		if page.Limit > 0 && count >= page.Limit {
+			count = page.Limit - 1
+		}
+		ir.metrics.customersReturned.Add(float64(count))
+	}
+	return customers, err
+}
+
+func (ir *instrumentedRepository) RestoreByIds(ctx context.Context, ids []int) (repository.DeleteInfo, error) {
+	start := time.Now()
+	restoreInfo, err := ir.next.RestoreByIds(ctx, ids)
+	ir.observe("restore_by_ids", start, err)
+	if err == nil {
+		ir.metrics.customersRestored.Add(float64(restoreInfo.Count))
+	}
+	return restoreInfo, err
+}
+
+func (ir *instrumentedRepository) RestoreByPrefix(ctx context.Context, filter repository.PrefixFilter) (repository.DeleteInfo, error) {
+	start := time.Now()
+	restoreInfo, err := ir.next.RestoreByPrefix(ctx, filter)
+	ir.observe("restore_by_prefix", start, err)
+	if err == nil {
+		ir.metrics.customersRestored.Add(float64(restoreInfo.Count))
+	}
+	return restoreInfo, err
+}
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vlegro/backend/config"
+)
+
+// cors answers the preflight requests of browsers and tells them which cross-origin
+// responses scripts may read.
+type cors struct {
+	anyOrigin        bool
+	origins          map[string]bool
+	methods          map[string]bool
+	headers          map[string]bool
+	allowMethods     string
+	allowHeaders     string
+	exposeHeaders    string
+	allowCredentials bool
+	maxAge           string
+}
+
+// CORS allows the origins of cfg to call the API from browsers. Requests from other origins
+// are served without CORS headers, so browsers keep their responses from scripts.
+func CORS(cfg config.CORS) func(http.Handler) http.Handler {
+	c := &cors{
+		origins:          map[string]bool{},
+		methods:          map[string]bool{},
+		headers:          map[string]bool{},
+		allowMethods:     strings.Join(cfg.AllowedMethods, ", "),
+		allowHeaders:     strings.Join(cfg.AllowedHeaders, ", "),
+		exposeHeaders:    strings.Join(cfg.ExposedHeaders, ", "),
+		allowCredentials: cfg.AllowCredentials,
+	}
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			c.anyOrigin = true
+		}
+		c.origins[origin] = true
+	}
+	for _, method := range cfg.AllowedMethods {
+		c.methods[strings.ToUpper(method)] = true
+	}
+	for _, header := range cfg.AllowedHeaders {
+		c.headers[http.CanonicalHeaderKey(header)] = true
+	}
+	if maxAge := time.Duration(cfg.MaxAge); maxAge > 0 {
+		c.maxAge = strconv.Itoa(int(maxAge.Seconds()))
+	}
+	return c.handler
+}
+
+func (c *cors) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		header.Add("Vary", "Origin")
+		if preflight {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+		}
+		if origin == "" || !c.allowsOrigin(origin) {
+			if preflight {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !preflight {
+			c.allowOrigin(header, origin)
+			if c.exposeHeaders != "" {
+				header.Set("Access-Control-Expose-Headers", c.exposeHeaders)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// A preflight not allowed gets no CORS headers, the browser then fails the request
+		if c.allowsMethod(r.Header.Get("Access-Control-Request-Method")) && c.allowsHeaders(r.Header.Get("Access-Control-Request-Headers")) {
+			c.allowOrigin(header, origin)
+			header.Set("Access-Control-Allow-Methods", c.allowMethods)
+			if c.allowHeaders != "" {
+				header.Set("Access-Control-Allow-Headers", c.allowHeaders)
+			}
+			if c.maxAge != "" {
+				header.Set("Access-Control-Max-Age", c.maxAge)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (c *cors) allowsOrigin(origin string) bool {
+	return c.anyOrigin || c.origins[origin]
+}
+
+func (c *cors) allowsMethod(method string) bool {
+	return c.methods[method]
+}
+
+// allowsHeaders checks the comma separated headers of Access-Control-Request-Headers.
+func (c *cors) allowsHeaders(requested string) bool {
+	for _, name := range strings.Split(requested, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && !c.headers[http.CanonicalHeaderKey(name)] {
+			return false
+		}
+	}
+	return true
+}
+
+// allowOrigin echoes origin, or * when any origin may read responses without credentials.
+func (c *cors) allowOrigin(header http.Header, origin string) {
+	if c.anyOrigin && !c.allowCredentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.allowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
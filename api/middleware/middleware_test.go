@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vlegro/backend/config"
+)
+
+// newRouter serves the customer routes behind the middlewares configured in cfg.
+func newRouter(cfg config.HTTP) chi.Router {
+	router := chi.NewRouter()
+	router.Use(Stack(cfg)...)
+	router.Get("/customers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[` + strings.Repeat(`{"name":"Клиент","phone":"+79990000000"},`, 50) + `{}]`))
+	})
+	router.Post("/customers", func(w http.ResponseWriter, r *http.Request) {
+		var tooLarge *http.MaxBytesError
+		if _, err := io.ReadAll(r.Body); errors.As(err, &tooLarge) {
+			TooLarge(w, r, tooLarge.Limit)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	router.Get("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("nil map")
+	})
+	return router
+}
+
+func serve(handler http.Handler, request *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestCORS(t *testing.T) {
+	cfg := config.Default().HTTP
+	cfg.CORS.AllowedOrigins = []string{"https://admin.example.com"}
+	router := newRouter(cfg)
+
+	preflight := func(origin, method, headers string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodOptions, "/customers", nil)
+		request.Header.Set("Origin", origin)
+		request.Header.Set("Access-Control-Request-Method", method)
+		request.Header.Set("Access-Control-Request-Headers", headers)
+		return serve(router, request)
+	}
+
+	recorder := preflight("https://admin.example.com", "DELETE", "x-api-key, content-type")
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.Equal(t, "https://admin.example.com", recorder.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, POST, PUT, PATCH, DELETE", recorder.Header().Get("Access-Control-Allow-Methods"))
+	assert.Contains(t, recorder.Header().Get("Access-Control-Allow-Headers"), "X-API-Key")
+	assert.Equal(t, "600", recorder.Header().Get("Access-Control-Max-Age"))
+	assert.Contains(t, recorder.Header().Values("Vary"), "Origin")
+
+	// Unknown origins, methods and headers get no CORS headers
+	for _, recorder := range []*httptest.ResponseRecorder{
+		preflight("https://evil.example.com", "DELETE", ""),
+		preflight("https://admin.example.com", "TRACE", ""),
+		preflight("https://admin.example.com", "GET", "X-Custom"),
+	} {
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+		assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Origin"))
+	}
+
+	// Actual requests expose the response headers
+	request := httptest.NewRequest(http.MethodGet, "/customers", nil)
+	request.Header.Set("Origin", "https://admin.example.com")
+	recorder = serve(router, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "https://admin.example.com", recorder.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, recorder.Header().Get("Access-Control-Expose-Headers"), "X-Request-ID")
+
+	request.Header.Set("Origin", "https://evil.example.com")
+	recorder = serve(router, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORS_AnyOrigin(t *testing.T) {
+	handler := CORS(config.CORS{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}, MaxAge: config.Duration(time.Minute)})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := httptest.NewRequest(http.MethodGet, "/customers", nil)
+	request.Header.Set("Origin", "https://any.example.com")
+	recorder := serve(handler, request)
+
+	assert.Equal(t, "*", recorder.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	recorder := serve(newRouter(config.Default().HTTP), httptest.NewRequest(http.MethodGet, "/customers", nil))
+
+	assert.Equal(t, "nosniff", recorder.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "DENY", recorder.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "no-store", recorder.Header().Get("Cache-Control"))
+	assert.Contains(t, recorder.Header().Get("Content-Security-Policy"), "frame-ancestors 'none'")
+	// CORS is off by default
+	assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestMaxBodySize(t *testing.T) {
+	cfg := config.Default().HTTP
+	cfg.MaxBodyBytes = 16
+	router := newRouter(cfg)
+
+	recorder := serve(router, httptest.NewRequest(http.MethodPost, "/customers", strings.NewReader(`{"name":"Клиент"}`)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"code":"body_too_large"`)
+
+	// Chunked bodies do not announce their size, they are cut off while reading
+	request := httptest.NewRequest(http.MethodPost, "/customers", io.MultiReader(strings.NewReader(`{"name":"Клиент"}`)))
+	request.ContentLength = -1
+	recorder = serve(router, request)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+
+	recorder = serve(router, httptest.NewRequest(http.MethodPost, "/customers", strings.NewReader(`{"name":"A"}`)))
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+}
+
+func TestRecover(t *testing.T) {
+	recorder := serve(newRouter(config.Default().HTTP), httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Equal(t, "application/problem+json", recorder.Header().Get("Content-Type"))
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	assert.Equal(t, "internal_error", body["code"])
+	assert.NotContains(t, recorder.Body.String(), "nil map")
+
+	// Aborted responses keep aborting
+	handler := Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		serve(handler, httptest.NewRequest(http.MethodGet, "/customers", nil))
+	})
+}
+
+func TestCompress(t *testing.T) {
+	router := newRouter(config.Default().HTTP)
+	request := httptest.NewRequest(http.MethodGet, "/customers", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+
+	recorder := serve(router, request)
+
+	assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+	reader, err := gzip.NewReader(recorder.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.True(t, json.Valid(body))
+
+	// Clients that do not ask get the plain body
+	recorder = serve(router, httptest.NewRequest(http.MethodGet, "/customers", nil))
+	assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+	assert.True(t, json.Valid(recorder.Body.Bytes()))
+}
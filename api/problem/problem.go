@@ -0,0 +1,58 @@
+// Package problem writes RFC 7807 problem details for every error response of the API.
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/vlegro/backend/api/logging"
+)
+
+// ContentType is the media type of problem details.
+const ContentType = "application/problem+json"
+
+// Problem is the body of an error response. Code is stable and meant for machines,
+// Message is meant for humans and may change.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Field     string `json:"field,omitempty"`
+	RequestId string `json:"requestId,omitempty"`
+}
+
+// New describes an error of the request r.
+func New(r *http.Request, status int, code, message string) *Problem {
+	return &Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Code:      code,
+		Message:   message,
+		RequestId: logging.RequestId(r.Context()),
+	}
+}
+
+// ForField names the input that caused the problem.
+func (p *Problem) ForField(field string) *Problem {
+	p.Field = field
+	return p
+}
+
+// Write sends the problem as the response to r.
+func (p *Problem) Write(w http.ResponseWriter, r *http.Request) {
+	WriteBody(w, r, p.Status, p)
+}
+
+// WriteBody sends a body that embeds a Problem with extra members.
+func WriteBody(w http.ResponseWriter, r *http.Request, status int, body interface{}) {
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logging.FromContext(r.Context()).Warn("Error encoding problem", "error", err)
+	}
+}
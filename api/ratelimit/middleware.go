@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/vlegro/backend/api/auth"
+	"github.com/vlegro/backend/api/logging"
+	"github.com/vlegro/backend/api/problem"
+)
+
+const codeRateLimited = "rate_limited"
+
+// Middleware limits the requests every client sends to the route called name, answering 429
+// with Retry-After once the bucket is empty. Clients are told apart by their principal, or by
+// their IP address when they did not authenticate, so use it after the authenticator. Every
+// response carries the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers.
+// When the store fails, the request is let through.
+func Middleware(store Store, name string, limit Limit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit.Unlimited() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			result, err := store.Take(r.Context(), name+" "+clientKey(r), limit)
+			if err != nil {
+				logging.FromContext(r.Context()).Warn("Rate limit store failed, request not limited", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			setHeaders(w, limit, result)
+			if !result.Allowed {
+				tooManyRequests(w, r, result, "too many requests, retry later")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Unauthorized limits the requests answered 401 per client address, so that credentials
+// cannot be guessed at the pace of the other limits. Use it before the authenticator: once
+// the bucket of an address is empty, its requests are answered 429 without being checked.
+// Every request holds a token until it is answered, so that concurrent guesses cannot all
+// pass before the first one fails; the token is refunded unless the answer is 401.
+func Unauthorized(store Store, name string, limit Limit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit.Unlimited() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := name + " " + addressKey(r)
+			result, err := store.Take(r.Context(), key, limit)
+			if err != nil {
+				logging.FromContext(r.Context()).Warn("Rate limit store failed, request not limited", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !result.Allowed {
+				setHeaders(w, limit, result)
+				tooManyRequests(w, r, result, "too many failed authentications, retry later")
+				return
+			}
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+			if ww.Status() == http.StatusUnauthorized {
+				return
+			}
+			if err := store.Refund(r.Context(), key, limit); err != nil {
+				logging.FromContext(r.Context()).Warn("Rate limit store failed, token not refunded", "error", err)
+			}
+		})
+	}
+}
+
+func setHeaders(w http.ResponseWriter, limit Limit, result Result) {
+	header := w.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+}
+
+func tooManyRequests(w http.ResponseWriter, r *http.Request, result Result, message string) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(result.RetryAfter), 1)))
+	problem.New(r, http.StatusTooManyRequests, codeRateLimited, message).Write(w, r)
+}
+
+// clientKey identifies the caller of r. Behind a proxy, RemoteAddr only holds the client
+// address once a middleware such as chi's RealIP has put it there.
+func clientKey(r *http.Request) string {
+	if principal, ok := auth.PrincipalFrom(r.Context()); ok {
+		return principal.String()
+	}
+	return addressKey(r)
+}
+
+// addressKey identifies the address r comes from.
+func addressKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
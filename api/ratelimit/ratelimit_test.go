@@ -0,0 +1,190 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vlegro/backend/api/auth"
+)
+
+// clock is a manual time source for MemoryStore.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func newTestStore() (*MemoryStore, *clock) {
+	c := &clock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	store.now = c.Now
+	return store, c
+}
+
+func TestMemoryStore(t *testing.T) {
+	store, c := newTestStore()
+	ctx := context.Background()
+	limit := Limit{Rate: 0.5, Burst: 2}
+
+	// A new bucket allows the burst
+	for remaining := 1; remaining >= 0; remaining-- {
+		result, err := store.Take(ctx, "client", limit)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, remaining, result.Remaining)
+	}
+
+	// Then one token comes back every two seconds
+	result, err := store.Take(ctx, "client", limit)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 2*time.Second, result.RetryAfter)
+	assert.Equal(t, 4*time.Second, result.Reset)
+
+	c.now = c.now.Add(time.Second)
+	result, _ = store.Take(ctx, "client", limit)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Second, result.RetryAfter)
+
+	c.now = c.now.Add(time.Second)
+	result, _ = store.Take(ctx, "client", limit)
+	assert.True(t, result.Allowed)
+
+	// Other keys have their own bucket
+	result, _ = store.Take(ctx, "other", limit)
+	assert.True(t, result.Allowed)
+
+	// Refunded tokens can be taken again, up to the burst
+	require.NoError(t, store.Refund(ctx, "client", limit))
+	result, _ = store.Take(ctx, "client", limit)
+	assert.True(t, result.Allowed)
+	require.NoError(t, store.Refund(ctx, "other", limit))
+	require.NoError(t, store.Refund(ctx, "other", limit))
+	result, _ = store.Take(ctx, "other", limit)
+	assert.Equal(t, 1, result.Remaining)
+
+	// Full buckets are dropped by the sweep
+	c.now = c.now.Add(sweepInterval)
+	_, _ = store.Take(ctx, "third", limit)
+	assert.Len(t, store.buckets, 1)
+}
+
+// failingStore always fails.
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, Limit) (Result, error) {
+	return Result{}, errors.New("connection refused")
+}
+
+func (failingStore) Refund(context.Context, string, Limit) error {
+	return errors.New("connection refused")
+}
+
+func TestMiddleware(t *testing.T) {
+	store, _ := newTestStore()
+	handler := Middleware(store, "deleteByPrefix", Limit{Rate: 0.2, Burst: 1})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(remoteAddr string, principal *auth.Principal) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodDelete, "/customers?prefix=Кл", nil)
+		request.RemoteAddr = remoteAddr
+		if principal != nil {
+			request = request.WithContext(auth.WithPrincipal(request.Context(), *principal))
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := serve("192.0.2.1:40000", nil)
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.Equal(t, "1", recorder.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", recorder.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "5", recorder.Header().Get("RateLimit-Reset"))
+
+	// The same address from another port is the same client
+	recorder = serve("192.0.2.1:40001", nil)
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Equal(t, "5", recorder.Header().Get("Retry-After"))
+	assert.Equal(t, "application/problem+json", recorder.Header().Get("Content-Type"))
+	assert.Contains(t, recorder.Body.String(), `"code":"rate_limited"`)
+
+	// Authenticated callers are limited by principal, not by address
+	ops := &auth.Principal{Subject: "ops-script", Method: auth.MethodAPIKey}
+	assert.Equal(t, http.StatusNoContent, serve("192.0.2.1:40002", ops).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve("198.51.100.7:40000", ops).Code)
+	assert.Equal(t, http.StatusNoContent, serve("198.51.100.7:40001", nil).Code)
+}
+
+func TestMiddleware_Passthrough(t *testing.T) {
+	tests := []struct {
+		name  string
+		store Store
+		limit Limit
+	}{
+		{name: "unlimited route", store: failingStore{}, limit: Limit{}},
+		{name: "failing store", store: failingStore{}, limit: Limit{Rate: 1, Burst: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Middleware(tt.store, "read", tt.limit)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}))
+
+			for i := 0; i < 3; i++ {
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/customers/1", nil))
+
+				assert.Equal(t, http.StatusNoContent, recorder.Code)
+				assert.Empty(t, recorder.Header().Get("RateLimit-Limit"))
+			}
+		})
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	store, c := newTestStore()
+	handler := Unauthorized(store, "authentication", Limit{Rate: 0.1, Burst: 2})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "s3cr3t-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(remoteAddr, key string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/customers/1", nil)
+		request.RemoteAddr = remoteAddr
+		request.Header.Set("X-API-Key", key)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	// Successful requests cost nothing
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusNoContent, serve("192.0.2.1:40000", "s3cr3t-key").Code)
+	}
+
+	// Failed ones empty the bucket of the address, then even the right key has to wait
+	assert.Equal(t, http.StatusUnauthorized, serve("192.0.2.1:40000", "guess-1").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("192.0.2.1:40001", "guess-2").Code)
+	recorder := serve("192.0.2.1:40002", "guess-3")
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Equal(t, "10", recorder.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("192.0.2.1:40003", "s3cr3t-key").Code)
+
+	// Other addresses are not affected
+	assert.Equal(t, http.StatusUnauthorized, serve("198.51.100.7:40000", "guess-1").Code)
+
+	c.now = c.now.Add(10 * time.Second)
+	assert.Equal(t, http.StatusNoContent, serve("192.0.2.1:40004", "s3cr3t-key").Code)
+}
@@ -0,0 +1,131 @@
+// Package ratelimit limits the request rate of every client with token buckets.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit allows Rate requests per second on average and bursts of up to Burst requests.
+// A zero Rate leaves the requests unlimited.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Unlimited reports whether l lets every request through.
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+// Result is the state of a bucket after Take.
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until a request is allowed again, zero when Allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Store keeps the buckets, implementations backed by a shared cache make the limits hold
+// across instances.
+type Store interface {
+	// Take takes a token from the bucket of key, a new bucket starts full.
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+	// Refund gives back a token taken from the bucket of key, the bucket never exceeds
+	// the burst.
+	Refund(ctx context.Context, key string, limit Limit) error
+}
+
+// sweepInterval is how often MemoryStore drops the buckets that refilled completely.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps the buckets of one instance in memory.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok || b.limit != limit {
+		b = &bucket{tokens: float64(limit.Burst), updated: now, limit: limit}
+		s.buckets[key] = b
+	}
+	b.refill(now)
+
+	result := Result{}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / limit.Rate)
+	}
+	result.Remaining = int(math.Floor(b.tokens))
+	result.Reset = seconds((float64(limit.Burst) - b.tokens) / limit.Rate)
+	return result, nil
+}
+
+func (s *MemoryStore) Refund(_ context.Context, key string, limit Limit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A bucket that was swept or replaced is already full
+	if b, ok := s.buckets[key]; ok && b.limit == limit {
+		b.refill(s.now())
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+1)
+	}
+	return nil
+}
+
+// refill adds the tokens earned since the last update, up to the burst.
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.Rate)
+		b.updated = now
+	}
+}
+
+// sweep drops full buckets, a new one would be the same. It keeps the map from growing with
+// every client ever seen.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
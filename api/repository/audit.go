@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// AuditDeleteByPrefix records a bulk delete.
+	AuditDeleteByPrefix = "delete_by_prefix"
+	// AuditDeleteById records the delete of a single customer.
+	AuditDeleteById = "delete_by_id"
+)
+
+// AuditInfo names who performs a change, it is recorded with every deletion.
+type AuditInfo struct {
+	Actor     string
+	RequestId string
+}
+
+// AuditEntry is one deletion recorded in customer_audit.
+type AuditEntry struct {
+	Id          int       `json:"id"`
+	OccurredAt  time.Time `json:"occurredAt"`
+	Action      string    `json:"action"`
+	Actor       string    `json:"actor"`
+	RequestId   string    `json:"requestId,omitempty"`
+	Prefixes    []string  `json:"prefixes"`
+	Fields      []string  `json:"fields"`
+	CustomerIds []int     `json:"customerIds"`
+	// Customers are the deleted rows as they were right after the deletion.
+	Customers []CustomerInfo `json:"customers"`
+}
+
+// AuditFilter selects audit entries, zero fields do not restrict the result.
+type AuditFilter struct {
+	// From and To bound OccurredAt, From inclusive and To exclusive.
+	From       time.Time
+	To         time.Time
+	Actor      string
+	CustomerId int
+}
+
+type AuditRepository interface {
+	// List returns entries newest first, page.AfterId continues with entries older than that id.
+	List(ctx context.Context, filter AuditFilter, page Page) ([]AuditEntry, error)
+}
+
+type AuditRepositoryImpl struct {
+	dbConnection *sql.DB
+}
+
+func NewAuditRepositoryImpl(dbConnection *sql.DB) *AuditRepositoryImpl {
+	return &AuditRepositoryImpl{
+		dbConnection: dbConnection,
+	}
+}
+
+func (a *AuditRepositoryImpl) List(ctx context.Context, filter AuditFilter, page Page) (entries []AuditEntry, err error) {
+	// Build the WHERE clause from the set filters only
+	conditions := []string{"TRUE"}
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if !filter.From.IsZero() {
+		addCondition("occurred_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("occurred_at < $%d", filter.To)
+	}
+	if filter.Actor != "" {
+		addCondition("actor = $%d", filter.Actor)
+	}
+	if filter.CustomerId > 0 {
+		addCondition("customer_ids @> ARRAY[$%d::integer]", filter.CustomerId)
+	}
+	if page.AfterId > 0 {
+		addCondition("id < $%d", page.AfterId)
+	}
+	args = append(args, page.Limit)
+
+	query := fmt.Sprintf(`
+		SELECT id, occurred_at, action, actor, request_id, prefixes, fields, customer_ids, customers
+		FROM customer_audit
+		WHERE %s
+		ORDER BY id DESC
+		LIMIT $%d`, strings.Join(conditions, " AND "), len(args))
+
+	ctx, span := startTableSpan(ctx, "customer_audit", query)
+	defer func() { endSpan(span, len(entries), err) }()
+	rows, err := a.dbConnection.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit: %w", err)
+	}
+	defer rows.Close()
+
+	entries = []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var requestId sql.NullString
+		var customerIds pq.Int64Array
+		var customers []byte
+		err := rows.Scan(
+			&entry.Id,
+			&entry.OccurredAt,
+			&entry.Action,
+			&entry.Actor,
+			&requestId,
+			pq.Array(&entry.Prefixes),
+			pq.Array(&entry.Fields),
+			&customerIds,
+			&customers,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entry.RequestId = requestId.String
+		entry.CustomerIds = make([]int, len(customerIds))
+		for i, id := range customerIds {
+			entry.CustomerIds[i] = int(id)
+		}
+		if err := json.Unmarshal(customers, &entry.Customers); err != nil {
+			return nil, fmt.Errorf("failed to decode audit snapshot: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return entries, nil
+}
+
+// insertAudit records a deletion of customers, within the transaction of the deletion
+// so that both are committed or neither is.
+func insertAudit(ctx context.Context, q querier, action string, info AuditInfo, filter PrefixFilter, customers []CustomerInfo) error {
+	snapshot, err := json.Marshal(customers)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit snapshot: %w", err)
+	}
+	ids := make([]int, len(customers))
+	for i, customer := range customers {
+		ids[i] = customer.Id
+	}
+	fields := make([]string, len(filter.Fields))
+	for i, field := range filter.Fields {
+		fields[i] = string(field)
+	}
+	prefixes := filter.Prefixes
+	if prefixes == nil {
+		prefixes = []string{}
+	}
+
+	const query = `
+		INSERT INTO customer_audit (action, actor, request_id, prefixes, fields, customer_ids, customers)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7)`
+	ctx, span := startTableSpan(ctx, "customer_audit", query)
+	count, err := execCount(ctx, q, query,
+		action,
+		info.Actor,
+		info.RequestId,
+		pq.Array(prefixes),
+		pq.Array(fields),
+		pq.Array(ids),
+		snapshot,
+	)
+	endSpan(span, int(count), err)
+	if err != nil {
+		return fmt.Errorf("failed to write audit: %w", err)
+	}
+	return nil
+}
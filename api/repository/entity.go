@@ -1,16 +1,48 @@
 package repository
 
+import "time"
+
 type CustomerInfo struct {
-	FirstName      *string  `json:"firstName" pg:",use_zero"`
-	LastName       *string  `json:"lastName,omitempty" pg:",use_zero"`
-	PatronymicName *string  `json:"patronymicName,omitempty" pg:",use_zero"`
-	Phone          *string  `json:"phone" pg:",use_zero"`
-	Email          *string  `json:"email" pg:",use_zero"`
-	Id             int      `json:"id" pg:",pk"`
-	tableName      struct{} `json:"-" pg:"customer"`
+	FirstName      *string    `json:"firstName" pg:",use_zero"`
+	LastName       *string    `json:"lastName,omitempty" pg:",use_zero"`
+	PatronymicName *string    `json:"patronymicName,omitempty" pg:",use_zero"`
+	Phone          *string    `json:"phone" pg:",use_zero"`
+	Email          *string    `json:"email" pg:",use_zero"`
+	Id             int        `json:"id" pg:",pk"`
+	DeletedAt      *time.Time `json:"deletedAt,omitempty" pg:",soft_delete"`
+	tableName      struct{}   `json:"-" pg:"customer"`
 }
 
 type DeleteInfo struct {
 	Count int   `json:"count"`
 	Ids   []int `json:"ids"`
+	// Names previews the full names of the matched customers, only set on dry runs.
+	Names  []string `json:"names,omitempty"`
+	DryRun bool     `json:"dryRun,omitempty"`
+	// ConfirmToken is set on dry runs that match more customers than may be deleted without confirmation.
+	ConfirmToken string `json:"confirmToken,omitempty"`
+}
+
+// DeleteOptions controls DeleteByPrefix.
+type DeleteOptions struct {
+	// DryRun selects the matching customers without deleting them.
+	DryRun bool
+	// Check is called with the matched ids before they are deleted, an error aborts the deletion
+	// and is returned as is.
+	Check func(ids []int) error
+	// Audit is recorded in customer_audit together with the deleted rows.
+	Audit AuditInfo
+}
+
+// WriteOptions controls Update and Patch.
+type WriteOptions struct {
+	// Check is called with the stored customer, locked until the write commits, an error aborts
+	// the write and is returned as is.
+	Check func(current CustomerInfo) error
+}
+
+// Page selects up to Limit customers with ids greater than AfterId.
+type Page struct {
+	AfterId int
+	Limit   int
 }
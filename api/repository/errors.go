@@ -0,0 +1,22 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrNotFound is returned when no customer matches the requested id.
+	ErrNotFound = errors.New("customer not found")
+	// ErrAlreadyExists is returned when a customer with the same id already exists.
+	ErrAlreadyExists = errors.New("customer already exists")
+)
+
+// uniqueViolation is the postgres error code for unique constraint violations.
+const uniqueViolation = "23505"
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Field is a customer attribute that can be searched by prefix.
+type Field string
+
+const (
+	FieldFirstName      Field = "firstName"
+	FieldLastName       Field = "lastName"
+	FieldPatronymicName Field = "patronymicName"
+	FieldEmail          Field = "email"
+	FieldPhone          Field = "phone"
+)
+
+// searchableColumns is the whitelist of columns a prefix may be matched against.
+// Only these names are ever formatted into SQL, the prefixes themselves are always bound.
+var searchableColumns = map[Field]string{
+	FieldFirstName:      "first_name",
+	FieldLastName:       "last_name",
+	FieldPatronymicName: "patronymic_name",
+	FieldEmail:          "email",
+	FieldPhone:          "phone",
+}
+
+// ParseField returns the Field with the given JSON name.
+func ParseField(name string) (Field, error) {
+	field := Field(name)
+	if _, ok := searchableColumns[field]; !ok {
+		return "", fmt.Errorf("unknown field %q", name)
+	}
+	return field, nil
+}
+
+// MatchMode selects how a prefix is compared with the column value.
+type MatchMode string
+
+const (
+	// MatchExact is a case-sensitive byte comparison.
+	MatchExact MatchMode = "exact"
+	// MatchInsensitive ignores letter case.
+	MatchInsensitive MatchMode = "insensitive"
+	// MatchNormalized compares NFC normalized, case-folded values with "ё" treated as "е".
+	MatchNormalized MatchMode = "normalized"
+)
+
+// matchExpressions wraps a column and a placeholder into comparable expressions per mode.
+// The insensitive and normalized ones are backed by expression indexes, see migration 3.
+var matchExpressions = map[MatchMode]string{
+	MatchExact:       `%s LIKE %s ESCAPE '\'`,
+	MatchInsensitive: `lower(%s) LIKE lower(%s) ESCAPE '\'`,
+	MatchNormalized:  `customer_search_key(%s) LIKE customer_search_key(%s) ESCAPE '\'`,
+}
+
+// ParseMatchMode returns the MatchMode with the given name.
+func ParseMatchMode(name string) (MatchMode, error) {
+	mode := MatchMode(name)
+	if _, ok := matchExpressions[mode]; !ok {
+		return "", fmt.Errorf("unknown match mode %q", name)
+	}
+	return mode, nil
+}
+
+// PrefixFilter matches customers having any of the Fields starting with any of the Prefixes.
+// An empty Fields list searches by first name, an empty Mode means MatchExact.
+// Prefixes are literal text unless Pattern is set, then "%" and "_" act as LIKE wildcards.
+// IncludeDeleted only applies to reads, deletes and restores always look at the rows they change.
+type PrefixFilter struct {
+	Prefixes       []string
+	Fields         []Field
+	Mode           MatchMode
+	Pattern        bool
+	IncludeDeleted bool
+}
+
+// whereClause builds the condition for the filter, its placeholders start at $1.
+func (f PrefixFilter) whereClause() (string, []interface{}, error) {
+	if len(f.Prefixes) == 0 {
+		return "", nil, fmt.Errorf("prefix list cannot be empty")
+	}
+
+	fields := f.Fields
+	if len(fields) == 0 {
+		fields = []Field{FieldFirstName}
+	}
+	columns := make([]string, len(fields))
+	for i, field := range fields {
+		column, ok := searchableColumns[field]
+		if !ok {
+			return "", nil, fmt.Errorf("unknown field %q", field)
+		}
+		columns[i] = column
+	}
+
+	mode := f.Mode
+	if mode == "" {
+		mode = MatchExact
+	}
+	expression, ok := matchExpressions[mode]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown match mode %q", mode)
+	}
+
+	// Every prefix is bound once and compared with every column
+	conditions := make([]string, 0, len(f.Prefixes)*len(columns))
+	args := make([]interface{}, len(f.Prefixes))
+	for i, prefix := range f.Prefixes {
+		if !f.Pattern {
+			prefix = escapeLike(prefix)
+		}
+		args[i] = prefix + "%"
+		for _, column := range columns {
+			conditions = append(conditions, fmt.Sprintf(expression, column, fmt.Sprintf("$%d", i+1)))
+		}
+	}
+
+	return strings.Join(conditions, " OR "), args, nil
+}
+
+// WithinPrefixes reports whether every customer matched by f has a searched field starting
+// with one of allowed, compared as exact text. Case-insensitive modes can match outside of
+// allowed, so they never are within it, and a pattern must start with an allowed prefix
+// taken literally.
+func (f PrefixFilter) WithinPrefixes(allowed []string) bool {
+	if f.Mode != "" && f.Mode != MatchExact {
+		return false
+	}
+	for _, prefix := range f.Prefixes {
+		within := false
+		for _, a := range allowed {
+			if f.Pattern {
+				a = escapeLike(a)
+			}
+			if a != "" && strings.HasPrefix(prefix, a) {
+				within = true
+				break
+			}
+		}
+		if !within {
+			return false
+		}
+	}
+	return true
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike makes LIKE treat every character of s literally, assuming ESCAPE '\'.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
@@ -1,6 +1,15 @@
 package repository
 
+import "context"
+
 type CustomerRepository interface {
-	DeleteByPrefix(prefix []string) (DeleteInfo, error)
-	GetByPrefix(prefix []string) ([]CustomerInfo, error)
+	Create(ctx context.Context, customer CustomerInfo) (CustomerInfo, error)
+	GetById(ctx context.Context, id int) (CustomerInfo, error)
+	Update(ctx context.Context, customer CustomerInfo, options WriteOptions) (CustomerInfo, error)
+	Patch(ctx context.Context, id int, patch CustomerInfo, options WriteOptions) (CustomerInfo, error)
+	DeleteById(ctx context.Context, id int, audit AuditInfo) error
+	DeleteByPrefix(ctx context.Context, filter PrefixFilter, options DeleteOptions) (DeleteInfo, error)
+	GetByPrefix(ctx context.Context, filter PrefixFilter, page Page) ([]CustomerInfo, error)
+	RestoreByIds(ctx context.Context, ids []int) (DeleteInfo, error)
+	RestoreByPrefix(ctx context.Context, filter PrefixFilter) (DeleteInfo, error)
 }
@@ -1,11 +1,20 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
+
+	"github.com/lib/pq"
+	"github.com/vlegro/backend/api/logging"
 )
 
+// customerColumns is the column list scanned by scanCustomer.
+const customerColumns = "id, first_name, last_name, patronymic_name, phone, email, deleted_at"
+
 type CustomerRepositoryImpl struct {
 	dbConnection *sql.DB
 }
@@ -16,65 +25,44 @@ func NewCustomerRepositoryImpl(dbConnection *sql.DB) *CustomerRepositoryImpl {
 	}
 }
 
-func (c *CustomerRepositoryImpl) GetByPrefix(prefixes []string) ([]CustomerInfo, error) {
+// GetByPrefix returns one page of matching customers ordered by id.
+// Deleted customers are skipped unless filter.IncludeDeleted is set.
+func (c *CustomerRepositoryImpl) GetByPrefix(ctx context.Context, filter PrefixFilter, page Page) (customers []CustomerInfo, err error) {
 	// Build the WHERE clause for multiple prefixes
-	conditions := make([]string, len(prefixes))
-	args := make([]interface{}, len(prefixes))
-	for i, prefix := range prefixes {
-		conditions[i] = fmt.Sprintf("first_name LIKE $%d", i+1)
-		args[i] = prefix + "%"
+	whereClause, args, err := filter.whereClause()
+	if err != nil {
+		return nil, err
 	}
-	whereClause := strings.Join(conditions, " OR ")
+	if !filter.IncludeDeleted {
+		whereClause = fmt.Sprintf("(%s) AND deleted_at IS NULL", whereClause)
+	}
+
+	// Keyset pagination: continue after the last id of the previous page
+	args = append(args, page.AfterId, page.Limit)
 
 	// Prepare the query
 	query := fmt.Sprintf(`
-		SELECT id, first_name, last_name, patronymic_name, phone, email 
-		FROM customer 
-		WHERE %s
-		ORDER BY id`, whereClause)
+		SELECT %s
+		FROM customer
+		WHERE (%s) AND id > $%d
+		ORDER BY id
+		LIMIT $%d`, customerColumns, whereClause, len(args)-1, len(args))
 
 	// Execute the query
-	rows, err := c.dbConnection.Query(query, args...)
+	ctx, span := startSpan(ctx, query)
+	defer func() { endSpan(span, len(customers), err) }()
+	rows, err := c.dbConnection.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute query: %w", err)
 	}
 	defer rows.Close()
 
 	// Process results
-	var customers []CustomerInfo
 	for rows.Next() {
-		var customer CustomerInfo
-		var firstName, lastName, patronymicName, phone, email sql.NullString
-		
-		err := rows.Scan(
-			&customer.Id,
-			&firstName,
-			&lastName,
-			&patronymicName,
-			&phone,
-			&email,
-		)
+		customer, err := scanCustomer(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
-
-		// Handle nullable fields
-		if firstName.Valid {
-			customer.FirstName = &firstName.String
-		}
-		if lastName.Valid {
-			customer.LastName = &lastName.String
-		}
-		if patronymicName.Valid {
-			customer.PatronymicName = &patronymicName.String
-		}
-		if phone.Valid {
-			customer.Phone = &phone.String
-		}
-		if email.Valid {
-			customer.Email = &email.String
-		}
-
 		customers = append(customers, customer)
 	}
 
@@ -85,41 +73,44 @@ func (c *CustomerRepositoryImpl) GetByPrefix(prefixes []string) ([]CustomerInfo,
 	return customers, nil
 }
 
-func (c *CustomerRepositoryImpl) DeleteByPrefix(prefixes []string) (DeleteInfo, error) {
+// DeleteByPrefix marks the matching customers as deleted, with options.DryRun the transaction
+// is rolled back after the select phase and the result also carries the customer names.
+func (c *CustomerRepositoryImpl) DeleteByPrefix(ctx context.Context, filter PrefixFilter, options DeleteOptions) (DeleteInfo, error) {
+	// Build the WHERE clause for multiple prefixes
+	whereClause, args, err := filter.whereClause()
+	if err != nil {
+		return DeleteInfo{}, err
+	}
+
 	// Start a transaction
-	tx, err := c.dbConnection.Begin()
+	tx, err := c.dbConnection.BeginTx(ctx, nil)
 	if err != nil {
 		return DeleteInfo{}, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback() // Will be ignored if transaction is committed
 
-	// Build the WHERE clause for multiple prefixes
-	conditions := make([]string, len(prefixes))
-	args := make([]interface{}, len(prefixes))
-	for i, prefix := range prefixes {
-		conditions[i] = fmt.Sprintf("first_name LIKE $%d", i+1)
-		args[i] = prefix + "%"
+	// First get the IDs of customers to be deleted and lock them until the transaction ends,
+	// a dry run only previews them and must not block concurrent writers
+	lockClause := "FOR UPDATE"
+	if options.DryRun {
+		lockClause = ""
 	}
-	whereClause := strings.Join(conditions, " OR ")
-
-	// First get the IDs of customers to be deleted
-	selectQuery := fmt.Sprintf("SELECT id FROM customer WHERE %s", whereClause)
-	rows, err := tx.Query(selectQuery, args...)
+	selectQuery := fmt.Sprintf(`
+		SELECT id, first_name, last_name, patronymic_name
+		FROM customer
+		WHERE (%s) AND deleted_at IS NULL
+		ORDER BY id
+		%s`, whereClause, lockClause)
+	ids, names, err := selectForDelete(ctx, tx, selectQuery, args)
 	if err != nil {
-		return DeleteInfo{}, fmt.Errorf("failed to query customers: %w", err)
+		return DeleteInfo{}, err
 	}
-	defer rows.Close()
 
-	var ids []int
-	for rows.Next() {
-		var id int
-		if err := rows.Scan(&id); err != nil {
-			return DeleteInfo{}, fmt.Errorf("failed to scan id: %w", err)
-		}
-		ids = append(ids, id)
-	}
-	if err = rows.Err(); err != nil {
-		return DeleteInfo{}, fmt.Errorf("error iterating rows: %w", err)
+	logging.FromContext(ctx).Debug("Selected customers to delete", "count", len(ids), "dry_run", options.DryRun)
+
+	// A dry run reports what would be deleted, the deferred rollback discards the transaction
+	if options.DryRun {
+		return DeleteInfo{Count: len(ids), Ids: ids, Names: names, DryRun: true}, nil
 	}
 
 	// If no customers found, return early
@@ -127,17 +118,25 @@ func (c *CustomerRepositoryImpl) DeleteByPrefix(prefixes []string) (DeleteInfo,
 		return DeleteInfo{Count: 0, Ids: []int{}}, nil
 	}
 
-	// Delete the customers
-	deleteQuery := fmt.Sprintf("DELETE FROM customer WHERE %s", whereClause)
-	result, err := tx.Exec(deleteQuery, args...)
+	if options.Check != nil {
+		if err := options.Check(ids); err != nil {
+			return DeleteInfo{}, err
+		}
+	}
+
+	// Delete exactly the selected customers, rows inserted or renamed since the select
+	// do not match by id and the selected ones are locked, so both phases agree
+	deleted, err := queryCustomers(ctx, tx,
+		fmt.Sprintf("UPDATE customer SET deleted_at = now() WHERE id = ANY($1) AND deleted_at IS NULL RETURNING %s", customerColumns),
+		pq.Array(ids),
+	)
 	if err != nil {
 		return DeleteInfo{}, fmt.Errorf("failed to delete customers: %w", err)
 	}
 
-	// Get number of affected rows
-	count, err := result.RowsAffected()
-	if err != nil {
-		return DeleteInfo{}, fmt.Errorf("failed to get affected rows: %w", err)
+	// The audit entry is committed with the deletion or not at all
+	if err := insertAudit(ctx, tx, AuditDeleteByPrefix, options.Audit, filter, deleted); err != nil {
+		return DeleteInfo{}, err
 	}
 
 	// Commit the transaction
@@ -145,8 +144,331 @@ func (c *CustomerRepositoryImpl) DeleteByPrefix(prefixes []string) (DeleteInfo,
 		return DeleteInfo{}, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
+	deletedIds := make([]int, len(deleted))
+	for i, customer := range deleted {
+		deletedIds[i] = customer.Id
+	}
 	return DeleteInfo{
-		Count: int(count),
-		Ids:   ids,
+		Count: len(deletedIds),
+		Ids:   deletedIds,
 	}, nil
 }
+
+// Create inserts a new customer, the id is generated by the database and customer.Id is ignored.
+func (c *CustomerRepositoryImpl) Create(ctx context.Context, customer CustomerInfo) (CustomerInfo, error) {
+	query := fmt.Sprintf(`
+		INSERT INTO customer (first_name, last_name, patronymic_name, phone, email)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING %s`, customerColumns)
+
+	created, err := queryCustomer(ctx, c.dbConnection, query,
+		customer.FirstName,
+		customer.LastName,
+		customer.PatronymicName,
+		customer.Phone,
+		customer.Email,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return CustomerInfo{}, ErrAlreadyExists
+		}
+		return CustomerInfo{}, fmt.Errorf("failed to insert customer: %w", err)
+	}
+
+	return created, nil
+}
+
+func (c *CustomerRepositoryImpl) GetById(ctx context.Context, id int) (CustomerInfo, error) {
+	query := fmt.Sprintf("SELECT %s FROM customer WHERE id = $1 AND deleted_at IS NULL", customerColumns)
+
+	customer, err := queryCustomer(ctx, c.dbConnection, query, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return CustomerInfo{}, ErrNotFound
+		}
+		return CustomerInfo{}, fmt.Errorf("failed to get customer: %w", err)
+	}
+
+	return customer, nil
+}
+
+// Update replaces every column of the customer, nil fields are stored as NULL.
+func (c *CustomerRepositoryImpl) Update(ctx context.Context, customer CustomerInfo, options WriteOptions) (CustomerInfo, error) {
+	query := fmt.Sprintf(`
+		UPDATE customer
+		SET first_name = $2, last_name = $3, patronymic_name = $4, phone = $5, email = $6
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING %s`, customerColumns)
+
+	return c.write(ctx, customer.Id, options, "failed to update customer", query,
+		customer.Id,
+		customer.FirstName,
+		customer.LastName,
+		customer.PatronymicName,
+		customer.Phone,
+		customer.Email,
+	)
+}
+
+// Patch updates only the non-nil fields of patch, other columns keep their values.
+func (c *CustomerRepositoryImpl) Patch(ctx context.Context, id int, patch CustomerInfo, options WriteOptions) (CustomerInfo, error) {
+	fields := []struct {
+		column string
+		value  *string
+	}{
+		{"first_name", patch.FirstName},
+		{"last_name", patch.LastName},
+		{"patronymic_name", patch.PatronymicName},
+		{"phone", patch.Phone},
+		{"email", patch.Email},
+	}
+
+	// Build the SET clause for provided fields only
+	assignments := make([]string, 0, len(fields))
+	args := []interface{}{id}
+	for _, field := range fields {
+		if field.value == nil {
+			continue
+		}
+		args = append(args, *field.value)
+		assignments = append(assignments, fmt.Sprintf("%s = $%d", field.column, len(args)))
+	}
+
+	// Nothing to change, return the current state
+	if len(assignments) == 0 {
+		return c.GetById(ctx, id)
+	}
+
+	query := fmt.Sprintf(`
+		UPDATE customer
+		SET %s
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING %s`, strings.Join(assignments, ", "), customerColumns)
+
+	return c.write(ctx, id, options, "failed to patch customer", query, args...)
+}
+
+// write runs the UPDATE ... RETURNING query of customer id, message describes the operation
+// in errors. With options.Check, the stored customer is locked and checked first in the same
+// transaction, so that no concurrent write changes it in between.
+func (c *CustomerRepositoryImpl) write(ctx context.Context, id int, options WriteOptions, message, query string, args ...interface{}) (CustomerInfo, error) {
+	queryError := func(err error) error {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("%s: %w", message, err)
+	}
+
+	if options.Check == nil {
+		written, err := queryCustomer(ctx, c.dbConnection, query, args...)
+		if err != nil {
+			return CustomerInfo{}, queryError(err)
+		}
+		return written, nil
+	}
+
+	tx, err := c.dbConnection.BeginTx(ctx, nil)
+	if err != nil {
+		return CustomerInfo{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+
+	selectQuery := fmt.Sprintf("SELECT %s FROM customer WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", customerColumns)
+	current, err := queryCustomer(ctx, tx, selectQuery, id)
+	if err != nil {
+		return CustomerInfo{}, queryError(err)
+	}
+	if err := options.Check(current); err != nil {
+		return CustomerInfo{}, err
+	}
+
+	written, err := queryCustomer(ctx, tx, query, args...)
+	if err != nil {
+		return CustomerInfo{}, queryError(err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return CustomerInfo{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return written, nil
+}
+
+// DeleteById marks the customer as deleted and records it in customer_audit,
+// it can be brought back with RestoreByIds.
+func (c *CustomerRepositoryImpl) DeleteById(ctx context.Context, id int, audit AuditInfo) error {
+	tx, err := c.dbConnection.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+
+	query := fmt.Sprintf("UPDATE customer SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL RETURNING %s", customerColumns)
+	deleted, err := queryCustomer(ctx, tx, query, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to delete customer: %w", err)
+	}
+
+	if err := insertAudit(ctx, tx, AuditDeleteById, audit, PrefixFilter{}, []CustomerInfo{deleted}); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// RestoreByIds brings back deleted customers, ids that are not deleted are ignored.
+func (c *CustomerRepositoryImpl) RestoreByIds(ctx context.Context, ids []int) (DeleteInfo, error) {
+	restoredIds, err := queryIds(ctx, c.dbConnection,
+		"UPDATE customer SET deleted_at = NULL WHERE id = ANY($1) AND deleted_at IS NOT NULL RETURNING id",
+		pq.Array(ids),
+	)
+	if err != nil {
+		return DeleteInfo{}, fmt.Errorf("failed to restore customers: %w", err)
+	}
+
+	return DeleteInfo{Count: len(restoredIds), Ids: restoredIds}, nil
+}
+
+// RestoreByPrefix brings back the deleted customers matching the filter.
+func (c *CustomerRepositoryImpl) RestoreByPrefix(ctx context.Context, filter PrefixFilter) (DeleteInfo, error) {
+	whereClause, args, err := filter.whereClause()
+	if err != nil {
+		return DeleteInfo{}, err
+	}
+
+	query := fmt.Sprintf("UPDATE customer SET deleted_at = NULL WHERE (%s) AND deleted_at IS NOT NULL RETURNING id", whereClause)
+	ids, err := queryIds(ctx, c.dbConnection, query, args...)
+	if err != nil {
+		return DeleteInfo{}, fmt.Errorf("failed to restore customers: %w", err)
+	}
+
+	return DeleteInfo{Count: len(ids), Ids: ids}, nil
+}
+
+// selectForDelete locks the customers about to be deleted and returns their ids and names.
+func selectForDelete(ctx context.Context, tx *sql.Tx, query string, args []interface{}) (ids []int, names []string, err error) {
+	ctx, span := startSpan(ctx, query)
+	defer func() { endSpan(span, len(ids), err) }()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query customers: %w", err)
+	}
+	defer rows.Close()
+
+	ids = []int{}
+	names = []string{}
+	for rows.Next() {
+		var id int
+		var firstName, lastName, patronymicName sql.NullString
+		if err := rows.Scan(&id, &firstName, &lastName, &patronymicName); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan id: %w", err)
+		}
+		ids = append(ids, id)
+		names = append(names, fullName(lastName, firstName, patronymicName))
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return ids, names, nil
+}
+
+// execCount runs a statement and returns the number of rows it changed.
+func execCount(ctx context.Context, q querier, query string, args ...interface{}) (int64, error) {
+	result, err := q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return count, nil
+}
+
+// scanIds collects and closes the ids returned by an UPDATE ... RETURNING id,
+// sorted as RETURNING has no order.
+func scanIds(rows *sql.Rows) ([]int, error) {
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	sort.Ints(ids)
+
+	return ids, nil
+}
+
+// fullName joins the non-empty name parts with spaces.
+func fullName(parts ...sql.NullString) string {
+	words := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Valid && part.String != "" {
+			words = append(words, part.String)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanCustomer reads a row selected with customerColumns.
+func scanCustomer(row rowScanner) (CustomerInfo, error) {
+	var customer CustomerInfo
+	var firstName, lastName, patronymicName, phone, email sql.NullString
+	var deletedAt sql.NullTime
+
+	err := row.Scan(
+		&customer.Id,
+		&firstName,
+		&lastName,
+		&patronymicName,
+		&phone,
+		&email,
+		&deletedAt,
+	)
+	if err != nil {
+		return CustomerInfo{}, err
+	}
+
+	// Handle nullable fields
+	if firstName.Valid {
+		customer.FirstName = &firstName.String
+	}
+	if lastName.Valid {
+		customer.LastName = &lastName.String
+	}
+	if patronymicName.Valid {
+		customer.PatronymicName = &patronymicName.String
+	}
+	if phone.Valid {
+		customer.Phone = &phone.String
+	}
+	if email.Valid {
+		customer.Email = &email.String
+	}
+	if deletedAt.Valid {
+		customer.DeletedAt = &deletedAt.Time
+	}
+
+	return customer, nil
+}
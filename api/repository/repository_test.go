@@ -1,9 +1,13 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"sync"
 	"testing"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -22,10 +26,14 @@ func TestCustomerRepository_GetByPrefix(t *testing.T) {
 	defer db.Close()
 
 	repo := NewCustomerRepositoryImpl(db)
+	ctx := context.Background()
 
 	tests := []struct {
 		name           string
 		prefixes       []string
+		fields         []Field
+		mode           MatchMode
+		pattern        bool
 		expectedCount  int
 		expectedNames  []string
 		expectedError  bool
@@ -44,6 +52,85 @@ func TestCustomerRepository_GetByPrefix(t *testing.T) {
 			expectedNames:  []string{"Клиент1", "Клиент2", "Клиент3", "Клиент4", "ДругойКлиент5"},
 			expectedError:  false,
 		},
+		{
+			name:          "last name match",
+			prefixes:      []string{"Клиентов"},
+			fields:        []Field{FieldLastName},
+			expectedCount: 5,
+			expectedNames: []string{"Клиент1", "Клиент2", "Клиент3", "Клиент4", "ДругойКлиент5"},
+			expectedError: false,
+		},
+		{
+			name:          "several fields combined",
+			prefixes:      []string{"test2@", "Другой"},
+			fields:        []Field{FieldFirstName, FieldEmail},
+			expectedCount: 2,
+			expectedNames: []string{"Клиент2", "ДругойКлиент5"},
+			expectedError: false,
+		},
+		{
+			name:          "exact match is case-sensitive",
+			prefixes:      []string{"клиент"},
+			expectedCount: 0,
+			expectedError: false,
+		},
+		{
+			name:          "case-insensitive match",
+			prefixes:      []string{"клиент"},
+			mode:          MatchInsensitive,
+			expectedCount: 4,
+			expectedNames: []string{"Клиент1", "Клиент2", "Клиент3", "Клиент4"},
+			expectedError: false,
+		},
+		{
+			// "и" followed by a combining breve is the decomposed form of "й"
+			name:          "normalized match treats ё as е",
+			prefixes:      []string{"КЛИЁНТ", "другои\u0306"},
+			mode:          MatchNormalized,
+			expectedCount: 5,
+			expectedNames: []string{"Клиент1", "Клиент2", "Клиент3", "Клиент4", "ДругойКлиент5"},
+			expectedError: false,
+		},
+		{
+			name:          "unknown match mode",
+			prefixes:      []string{"Клиент"},
+			mode:          "soundex",
+			expectedError: true,
+		},
+		{
+			name:          "percent is not a wildcard",
+			prefixes:      []string{"%"},
+			expectedCount: 0,
+			expectedError: false,
+		},
+		{
+			name:          "underscore is not a wildcard",
+			prefixes:      []string{"_лиент", "Клиент_"},
+			mode:          MatchInsensitive,
+			expectedCount: 0,
+			expectedError: false,
+		},
+		{
+			name:          "backslash is literal",
+			prefixes:      []string{`\`, `\%`},
+			mode:          MatchNormalized,
+			expectedCount: 0,
+			expectedError: false,
+		},
+		{
+			name:          "pattern mode keeps wildcards",
+			prefixes:      []string{"_лиент"},
+			pattern:       true,
+			expectedCount: 4,
+			expectedNames: []string{"Клиент1", "Клиент2", "Клиент3", "Клиент4"},
+			expectedError: false,
+		},
+		{
+			name:          "unknown field",
+			prefixes:      []string{"Клиент"},
+			fields:        []Field{"first_name; DROP TABLE customer"},
+			expectedError: true,
+		},
 		{
 			name:           "no matches",
 			prefixes:       []string{"NonExistent"},
@@ -62,7 +149,7 @@ func TestCustomerRepository_GetByPrefix(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			customers, err := repo.GetByPrefix(tt.prefixes)
+			customers, err := repo.GetByPrefix(ctx, PrefixFilter{Prefixes: tt.prefixes, Fields: tt.fields, Mode: tt.mode, Pattern: tt.pattern}, Page{Limit: 100})
 
 			if tt.expectedError {
 				assert.Error(t, err)
@@ -83,11 +170,29 @@ func TestCustomerRepository_GetByPrefix(t *testing.T) {
 	}
 }
 
+func TestCustomerRepository_GetByPrefix_Pagination(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	repo := NewCustomerRepositoryImpl(db)
+	ctx := context.Background()
+
+	first, err := repo.GetByPrefix(ctx, PrefixFilter{Prefixes: []string{"Клиент"}}, Page{Limit: 3})
+	require.NoError(t, err)
+	require.Len(t, first, 3)
+
+	rest, err := repo.GetByPrefix(ctx, PrefixFilter{Prefixes: []string{"Клиент"}}, Page{AfterId: first[2].Id, Limit: 3})
+	require.NoError(t, err)
+	require.Len(t, rest, 1)
+	assert.Greater(t, rest[0].Id, first[2].Id)
+}
+
 func TestCustomerRepository_DeleteByPrefix(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
 
 	repo := NewCustomerRepositoryImpl(db)
+	ctx := context.Background()
 
 	tests := []struct {
 		name           string
@@ -107,15 +212,9 @@ func TestCustomerRepository_DeleteByPrefix(t *testing.T) {
 			},
 			cleanup: func(t *testing.T, db *sql.DB) {
 				// Restore original data
-				_, err := db.Exec(`
-					INSERT INTO customer (id, first_name, last_name, patronymic_name, phone, email)
-					VALUES 
-						(1, 'Клиент1', 'Клиентов1', 'Клиентович1', '77777777777', 'test1@test.ru'),
-						(2, 'Клиент2', 'Клиентов2', 'Клиентович2', '77777777777', 'test2@test.ru'),
-						(3, 'Клиент3', 'Клиентов3', 'Клиентович3', '77777777777', 'test3@test.ru'),
-						(4, 'Клиент4', 'Клиентов4', 'Клиентович4', '77777777777', 'test4@test.ru')
-				`)
+				restored, err := NewCustomerRepositoryImpl(db).RestoreByIds(ctx, []int{1, 2, 3, 4})
 				require.NoError(t, err)
+				assert.Equal(t, []int{1, 2, 3, 4}, restored.Ids)
 			},
 		},
 		{
@@ -128,16 +227,24 @@ func TestCustomerRepository_DeleteByPrefix(t *testing.T) {
 			},
 			cleanup: func(t *testing.T, db *sql.DB) {
 				// Restore all original data
-				_, err := db.Exec(`
-					INSERT INTO customer (id, first_name, last_name, patronymic_name, phone, email)
-					VALUES 
-						(1, 'Клиент1', 'Клиентов1', 'Клиентович1', '77777777777', 'test1@test.ru'),
-						(2, 'Клиент2', 'Клиентов2', 'Клиентович2', '77777777777', 'test2@test.ru'),
-						(3, 'Клиент3', 'Клиентов3', 'Клиентович3', '77777777777', 'test3@test.ru'),
-						(4, 'Клиент4', 'Клиентов4', 'Клиентович4', '77777777777', 'test4@test.ru'),
-						(5, 'ДругойКлиент5', 'Клиентов5', 'Клиентович5', '77777777777', 'test5@test.ru')
-				`)
+				restored, err := NewCustomerRepositoryImpl(db).RestoreByPrefix(ctx, PrefixFilter{Prefixes: []string{"Клиент", "Другой"}})
 				require.NoError(t, err)
+				assert.Equal(t, []int{1, 2, 3, 4, 5}, restored.Ids)
+			},
+		},
+		{
+			name:          "delete wildcard prefix",
+			prefixes:      []string{"%", "_"},
+			expectedCount: 0,
+			expectedError: false,
+			setup: func(t *testing.T, db *sql.DB) {
+				// No setup needed
+			},
+			cleanup: func(t *testing.T, db *sql.DB) {
+				// Nothing must have been deleted
+				var count int
+				require.NoError(t, db.QueryRow("SELECT count(*) FROM customer WHERE deleted_at IS NULL").Scan(&count))
+				assert.GreaterOrEqual(t, count, 5)
 			},
 		},
 		{
@@ -174,7 +281,7 @@ func TestCustomerRepository_DeleteByPrefix(t *testing.T) {
 			}
 
 			// Run test
-			result, err := repo.DeleteByPrefix(tt.prefixes)
+			result, err := repo.DeleteByPrefix(ctx, PrefixFilter{Prefixes: tt.prefixes}, DeleteOptions{})
 
 			// Verify results
 			if tt.expectedError {
@@ -193,3 +300,341 @@ func TestCustomerRepository_DeleteByPrefix(t *testing.T) {
 		})
 	}
 }
+
+func TestCustomerRepository_SoftDelete(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	repo := NewCustomerRepositoryImpl(db)
+	ctx := context.Background()
+	filter := PrefixFilter{Prefixes: []string{"Другой"}}
+
+	result, err := repo.DeleteByPrefix(ctx, filter, DeleteOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []int{5}, result.Ids)
+
+	// The row is kept but hidden from reads
+	var deletedAt sql.NullTime
+	require.NoError(t, db.QueryRow("SELECT deleted_at FROM customer WHERE id = 5").Scan(&deletedAt))
+	assert.True(t, deletedAt.Valid)
+	_, err = repo.GetById(ctx, 5)
+	assert.ErrorIs(t, err, ErrNotFound)
+	customers, err := repo.GetByPrefix(ctx, filter, Page{Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, customers)
+
+	// Unless deleted customers are asked for
+	filter.IncludeDeleted = true
+	customers, err = repo.GetByPrefix(ctx, filter, Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, customers, 1)
+	assert.NotNil(t, customers[0].DeletedAt)
+
+	// Deleting again matches nothing
+	result, err = repo.DeleteByPrefix(ctx, filter, DeleteOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Count)
+
+	restored, err := repo.RestoreByPrefix(ctx, filter)
+	require.NoError(t, err)
+	assert.Equal(t, []int{5}, restored.Ids)
+
+	customer, err := repo.GetById(ctx, 5)
+	require.NoError(t, err)
+	assert.Nil(t, customer.DeletedAt)
+
+	// Restoring a customer that is not deleted is a no-op
+	restored, err = repo.RestoreByIds(ctx, []int{5})
+	require.NoError(t, err)
+	assert.Equal(t, 0, restored.Count)
+}
+
+func TestCustomerRepository_DeleteByPrefix_Concurrent(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	repo := NewCustomerRepositoryImpl(db)
+	ctx := context.Background()
+	const name = "Гонка"
+	defer db.Exec("DELETE FROM customer WHERE first_name = $1", name)
+
+	// Writers keep inserting matching customers while the deletes run
+	stop := make(chan struct{})
+	var writers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, err := repo.Create(ctx, CustomerInfo{FirstName: strPtr(name)}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	var mu sync.Mutex
+	reported := map[int]bool{}
+	var deleters sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		deleters.Add(1)
+		go func() {
+			defer deleters.Done()
+			for j := 0; j < 25; j++ {
+				result, err := repo.DeleteByPrefix(ctx, PrefixFilter{Prefixes: []string{name}}, DeleteOptions{})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				assert.Equal(t, result.Count, len(result.Ids))
+
+				mu.Lock()
+				for _, id := range result.Ids {
+					assert.False(t, reported[id], "id %d reported by two deletes", id)
+					reported[id] = true
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	deleters.Wait()
+	close(stop)
+	writers.Wait()
+
+	// Every deleted row was reported and every reported row was deleted
+	rows, err := db.Query("SELECT id FROM customer WHERE first_name = $1 AND deleted_at IS NOT NULL", name)
+	require.NoError(t, err)
+	deleted, err := scanIds(rows)
+	require.NoError(t, err)
+	assert.Len(t, deleted, len(reported))
+	for _, id := range deleted {
+		assert.True(t, reported[id], "id %d deleted but not reported", id)
+	}
+}
+
+func TestCustomerRepository_DeleteByPrefix_DryRun(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	repo := NewCustomerRepositoryImpl(db)
+	ctx := context.Background()
+
+	result, err := repo.DeleteByPrefix(ctx, PrefixFilter{Prefixes: []string{"Другой"}}, DeleteOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.True(t, result.DryRun)
+	assert.Equal(t, 1, result.Count)
+	assert.Equal(t, []int{5}, result.Ids)
+	assert.Equal(t, []string{"Клиентов5 ДругойКлиент5 Клиентович5"}, result.Names)
+
+	// Nothing was deleted
+	customer, err := repo.GetById(ctx, 5)
+	require.NoError(t, err)
+	assert.Equal(t, "ДругойКлиент5", *customer.FirstName)
+}
+
+func TestCustomerRepository_DeleteByPrefix_DryRunDoesNotLock(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id, first_name, last_name, patronymic_name FROM customer WHERE .* ORDER BY id\s*$`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "first_name", "last_name", "patronymic_name"}).
+			AddRow(5, "ДругойКлиент5", "Клиентов5", nil))
+	mock.ExpectRollback()
+
+	result, err := NewCustomerRepositoryImpl(db).DeleteByPrefix(context.Background(),
+		PrefixFilter{Prefixes: []string{"Другой"}}, DeleteOptions{DryRun: true})
+
+	require.NoError(t, err)
+	assert.Equal(t, []int{5}, result.Ids)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCustomerRepository_Patch_CheckLocksRow(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	columns := []string{"id", "first_name", "last_name", "patronymic_name", "phone", "email", "deleted_at"}
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .* FROM customer WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "Клиент1", nil, nil, "77777777777", nil, nil))
+	mock.ExpectRollback()
+
+	// A failed check aborts the write, its error comes back as is
+	invalid := errors.New("invalid phone")
+	var current CustomerInfo
+	_, err = NewCustomerRepositoryImpl(db).Patch(context.Background(), 1, CustomerInfo{Phone: strPtr("7777")}, WriteOptions{
+		Check: func(stored CustomerInfo) error {
+			current = stored
+			return invalid
+		},
+	})
+
+	assert.Equal(t, invalid, err)
+	assert.Equal(t, "77777777777", *current.Phone)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCustomerRepository_ContextCancelled(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	repo := NewCustomerRepositoryImpl(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := repo.GetByPrefix(ctx, PrefixFilter{Prefixes: []string{"Клиент"}}, Page{Limit: 10})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = repo.DeleteByPrefix(ctx, PrefixFilter{Prefixes: []string{"Клиент"}}, DeleteOptions{})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Nothing was deleted by the cancelled request
+	customers, err := repo.GetByPrefix(context.Background(), PrefixFilter{Prefixes: []string{"Клиент"}}, Page{Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, customers, 4)
+}
+
+func TestCustomerRepository_CRUD(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	repo := NewCustomerRepositoryImpl(db)
+	ctx := context.Background()
+
+	created, err := repo.Create(ctx, CustomerInfo{FirstName: strPtr("Тест"), Email: strPtr("crud@test.ru")})
+	require.NoError(t, err)
+	id := created.Id
+	defer db.Exec("DELETE FROM customer WHERE id = $1", id)
+	assert.Greater(t, id, 5)
+	assert.Equal(t, "Тест", *created.FirstName)
+
+	// Every create gets its own id
+	other, err := repo.Create(ctx, CustomerInfo{FirstName: strPtr("Тест")})
+	require.NoError(t, err)
+	defer db.Exec("DELETE FROM customer WHERE id = $1", other.Id)
+	assert.NotEqual(t, id, other.Id)
+
+	patched, err := repo.Patch(ctx, id, CustomerInfo{LastName: strPtr("Тестов")}, WriteOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "Тест", *patched.FirstName)
+	assert.Equal(t, "Тестов", *patched.LastName)
+
+	var locked CustomerInfo
+	updated, err := repo.Update(ctx, CustomerInfo{Id: id, FirstName: strPtr("Обновлен")}, WriteOptions{
+		Check: func(current CustomerInfo) error {
+			locked = current
+			return nil
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Обновлен", *updated.FirstName)
+	assert.Nil(t, updated.LastName)
+	assert.Nil(t, updated.Email)
+	assert.Equal(t, patched, locked)
+
+	fetched, err := repo.GetById(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, updated, fetched)
+
+	require.NoError(t, repo.DeleteById(ctx, id, AuditInfo{Actor: "test"}))
+	assert.ErrorIs(t, repo.DeleteById(ctx, id, AuditInfo{Actor: "test"}), ErrNotFound)
+
+	_, err = repo.GetById(ctx, id)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = repo.Update(ctx, CustomerInfo{Id: id}, WriteOptions{})
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = repo.Patch(ctx, id, CustomerInfo{Phone: strPtr("1")}, WriteOptions{Check: func(CustomerInfo) error { return nil }})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestCustomerRepository_DeleteByPrefix_Audit(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	repo := NewCustomerRepositoryImpl(db)
+	auditRepo := NewAuditRepositoryImpl(db)
+	ctx := context.Background()
+	filter := PrefixFilter{Prefixes: []string{"Другой"}}
+	audit := AuditInfo{Actor: "audit-test", RequestId: "req-audit-1"}
+
+	result, err := repo.DeleteByPrefix(ctx, filter, DeleteOptions{Audit: audit})
+	require.NoError(t, err)
+	require.Equal(t, []int{5}, result.Ids)
+	defer func() {
+		_, err := repo.RestoreByIds(ctx, []int{5})
+		require.NoError(t, err)
+	}()
+
+	entries, err := auditRepo.List(ctx, AuditFilter{Actor: "audit-test", CustomerId: 5}, Page{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	entry := entries[0]
+	assert.Equal(t, AuditDeleteByPrefix, entry.Action)
+	assert.Equal(t, "req-audit-1", entry.RequestId)
+	assert.Equal(t, []string{"Другой"}, entry.Prefixes)
+	assert.Equal(t, []int{5}, entry.CustomerIds)
+	require.Len(t, entry.Customers, 1)
+	assert.Equal(t, "ДругойКлиент5", *entry.Customers[0].FirstName)
+	assert.NotNil(t, entry.Customers[0].DeletedAt)
+
+	// A dry run deletes nothing and writes no entry
+	_, err = repo.DeleteByPrefix(ctx, PrefixFilter{Prefixes: []string{"Клиент"}}, DeleteOptions{DryRun: true, Audit: audit})
+	require.NoError(t, err)
+	entries, err = auditRepo.List(ctx, AuditFilter{Actor: "audit-test"}, Page{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, entry.Id, entries[0].Id)
+}
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "Клиент", expected: "Клиент"},
+		{input: "%", expected: `\%`},
+		{input: "_", expected: `\_`},
+		{input: `\`, expected: `\\`},
+		{input: `50%_off\`, expected: `50\%\_off\\`},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, escapeLike(tt.input), tt.input)
+	}
+}
+
+func TestPrefixFilter_WithinPrefixes(t *testing.T) {
+	allowed := []string{"Тест", "50%"}
+	tests := []struct {
+		name     string
+		filter   PrefixFilter
+		expected bool
+	}{
+		{name: "same prefix", filter: PrefixFilter{Prefixes: []string{"Тест"}}, expected: true},
+		{name: "longer prefix", filter: PrefixFilter{Prefixes: []string{"Тестов", "50%_off"}}, expected: true},
+		{name: "one prefix outside", filter: PrefixFilter{Prefixes: []string{"Тестов", "Клиент"}}, expected: false},
+		{name: "shorter prefix", filter: PrefixFilter{Prefixes: []string{"Тес"}}, expected: false},
+		{name: "insensitive mode", filter: PrefixFilter{Prefixes: []string{"Тест"}, Mode: MatchInsensitive}, expected: false},
+		{name: "pattern after the allowed text", filter: PrefixFilter{Prefixes: []string{"Тест_"}, Pattern: true}, expected: true},
+		{name: "wildcard in place of allowed text", filter: PrefixFilter{Prefixes: []string{"50%"}, Pattern: true}, expected: false},
+		{name: "escaped allowed text", filter: PrefixFilter{Prefixes: []string{`50\%`}, Pattern: true}, expected: true},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, tt.filter.WithinPrefixes(allowed), tt.name)
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
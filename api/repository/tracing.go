@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/vlegro/backend/api/repository")
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// startSpan starts the span of one SQL statement on the customer table. The statement only
+// holds placeholders, so the span never carries customer data.
+func startSpan(ctx context.Context, statement string) (context.Context, trace.Span) {
+	return startTableSpan(ctx, "customer", statement)
+}
+
+// startTableSpan is startSpan for statements on other tables.
+func startTableSpan(ctx context.Context, table string, statement string) (context.Context, trace.Span) {
+	statement = strings.Join(strings.Fields(statement), " ")
+	operation, _, _ := strings.Cut(statement, " ")
+	return tracer.Start(ctx, operation+" "+table, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.operation", operation),
+		attribute.String("db.statement", statement),
+	))
+}
+
+// endSpan records the number of rows the statement returned or changed and ends span.
+// sql.ErrNoRows is an empty result, not a failure.
+func endSpan(span trace.Span, rows int, err error) {
+	span.SetAttributes(attribute.Int("db.rows", rows))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// queryCustomer runs a statement returning at most one row of customerColumns.
+func queryCustomer(ctx context.Context, q querier, query string, args ...interface{}) (CustomerInfo, error) {
+	ctx, span := startSpan(ctx, query)
+	customer, err := scanCustomer(q.QueryRowContext(ctx, query, args...))
+	rows := 1
+	if err != nil {
+		rows = 0
+	}
+	endSpan(span, rows, err)
+	return customer, err
+}
+
+// queryIds runs an UPDATE ... RETURNING id, see scanIds.
+func queryIds(ctx context.Context, q querier, query string, args ...interface{}) ([]int, error) {
+	ctx, span := startSpan(ctx, query)
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		endSpan(span, 0, err)
+		return nil, err
+	}
+	ids, err := scanIds(rows)
+	endSpan(span, len(ids), err)
+	return ids, err
+}
+
+// queryCustomers runs an UPDATE ... RETURNING customerColumns, the customers are sorted by id
+// as RETURNING has no order.
+func queryCustomers(ctx context.Context, q querier, query string, args ...interface{}) (customers []CustomerInfo, err error) {
+	ctx, span := startSpan(ctx, query)
+	defer func() { endSpan(span, len(customers), err) }()
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	customers = []CustomerInfo{}
+	for rows.Next() {
+		customer, err := scanCustomer(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		customers = append(customers, customer)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	sort.Slice(customers, func(i, j int) bool { return customers[i].Id < customers[j].Id })
+
+	return customers, nil
+}
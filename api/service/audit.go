@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/vlegro/backend/api/auth"
+	"github.com/vlegro/backend/api/logging"
+	"github.com/vlegro/backend/api/repository"
+	"github.com/vlegro/backend/api/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// AnonymousActor is recorded for deletions of callers that did not authenticate.
+const AnonymousActor = "anonymous"
+
+// auditInfo returns what is recorded in customer_audit for the changes made with ctx.
+func auditInfo(ctx context.Context) repository.AuditInfo {
+	actor := AnonymousActor
+	if principal, ok := auth.PrincipalFrom(ctx); ok {
+		actor = principal.String()
+	}
+	return repository.AuditInfo{Actor: actor, RequestId: logging.RequestId(ctx)}
+}
+
+type AuditService struct {
+	auditRepository repository.AuditRepository
+	policy          *auth.Policy
+	defaultPageSize int
+	maxPageSize     int
+}
+
+// AuditOption configures an AuditService.
+type AuditOption func(*AuditService)
+
+// WithAuditPageSizes replaces DefaultPageSize and MaxPageSize.
+func WithAuditPageSizes(defaultSize, maxSize int) AuditOption {
+	return func(as *AuditService) {
+		as.defaultPageSize = defaultSize
+		as.maxPageSize = maxSize
+	}
+}
+
+// NewAuditService requires the audit:read permission from callers, a nil policy allows everyone.
+func NewAuditService(auditRepository repository.AuditRepository, policy *auth.Policy, options ...AuditOption) *AuditService {
+	as := &AuditService{
+		auditRepository: auditRepository,
+		policy:          policy,
+		defaultPageSize: DefaultPageSize,
+		maxPageSize:     MaxPageSize,
+	}
+	for _, option := range options {
+		option(as)
+	}
+	return as
+}
+
+// AuditQuery selects audit entries, zero fields do not restrict the result.
+type AuditQuery struct {
+	// From and To bound the time of the deletion, From inclusive and To exclusive.
+	From       time.Time
+	To         time.Time
+	Actor      string
+	CustomerId int
+}
+
+// AuditPage is one page of audit entries, newest first. NextCursor is empty on the last page.
+type AuditPage struct {
+	Entries    []repository.AuditEntry `json:"entries"`
+	NextCursor string                  `json:"nextCursor,omitempty"`
+}
+
+// List returns the recorded deletions matching query.
+func (as *AuditService) List(ctx context.Context, query AuditQuery, pageRequest PageRequest) (_ AuditPage, err error) {
+	ctx, span := tracer.Start(ctx, "AuditService.List")
+	defer tracing.End(span, &err)
+
+	// Check permissions
+	if _, err := authorize(ctx, as.policy, auth.PermissionAudit); err != nil {
+		return AuditPage{}, err
+	}
+
+	// Validate input
+	if !query.From.IsZero() && !query.To.IsZero() && !query.From.Before(query.To) {
+		return AuditPage{}, validationError("from", "from must be before to")
+	}
+	if query.CustomerId < 0 {
+		return AuditPage{}, validationError("customerId", "customerId must be a positive number")
+	}
+	if pageRequest.Limit < 0 {
+		return AuditPage{}, validationError("limit", "limit cannot be negative")
+	}
+	afterId, err := decodeCursor(pageRequest.Cursor)
+	if err != nil {
+		return AuditPage{}, err
+	}
+
+	// Enforce the page size
+	limit := pageRequest.Limit
+	if limit == 0 {
+		limit = as.defaultPageSize
+	}
+	if limit > as.maxPageSize {
+		limit = as.maxPageSize
+	}
+
+	// One extra entry tells whether there is a next page
+	filter := repository.AuditFilter{From: query.From, To: query.To, Actor: query.Actor, CustomerId: query.CustomerId}
+	entries, err := as.auditRepository.List(ctx, filter, repository.Page{AfterId: afterId, Limit: limit + 1})
+	if err != nil {
+		return AuditPage{}, repositoryError(err)
+	}
+
+	page := AuditPage{Entries: entries}
+	if len(entries) > limit {
+		page.Entries = entries[:limit]
+		page.NextCursor = encodeCursor(page.Entries[limit-1].Id)
+	}
+	if page.Entries == nil {
+		page.Entries = []repository.AuditEntry{}
+	}
+	span.SetAttributes(attribute.Int("audit.count", len(page.Entries)))
+
+	return page, nil
+}
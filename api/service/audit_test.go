@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/vlegro/backend/api/auth"
+	"github.com/vlegro/backend/api/repository"
+)
+
+// MockAuditRepository is a mock implementation of AuditRepository
+type MockAuditRepository struct {
+	mock.Mock
+}
+
+func (m *MockAuditRepository) List(ctx context.Context, filter repository.AuditFilter, page repository.Page) ([]repository.AuditEntry, error) {
+	args := m.Called(filter, page)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]repository.AuditEntry), args.Error(1)
+}
+
+func TestAuditService_List(t *testing.T) {
+	mockRepo := new(MockAuditRepository)
+	ctx := context.Background()
+	service := NewAuditService(mockRepo, nil)
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+	filter := repository.AuditFilter{From: from, To: to, Actor: "admin", CustomerId: 5}
+	mockRepo.On("List", filter, repository.Page{Limit: 3}).
+		Return([]repository.AuditEntry{{Id: 9}, {Id: 7}, {Id: 4}}, nil).Once()
+	mockRepo.On("List", filter, repository.Page{AfterId: 7, Limit: 3}).
+		Return([]repository.AuditEntry{{Id: 4}}, nil).Once()
+
+	query := AuditQuery{From: from, To: to, Actor: "admin", CustomerId: 5}
+	page, err := service.List(ctx, query, PageRequest{Limit: 2})
+	require.NoError(t, err)
+	assert.Len(t, page.Entries, 2)
+	require.NotEmpty(t, page.NextCursor)
+
+	page, err = service.List(ctx, query, PageRequest{Limit: 2, Cursor: page.NextCursor})
+	require.NoError(t, err)
+	assert.Equal(t, []repository.AuditEntry{{Id: 4}}, page.Entries)
+	assert.Empty(t, page.NextCursor)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAuditService_List_PageSizes(t *testing.T) {
+	mockRepo := new(MockAuditRepository)
+	ctx := context.Background()
+	service := NewAuditService(mockRepo, nil, WithAuditPageSizes(10, 20))
+
+	mockRepo.On("List", repository.AuditFilter{}, repository.Page{Limit: 11}).Return([]repository.AuditEntry{}, nil).Once()
+	_, err := service.List(ctx, AuditQuery{}, PageRequest{})
+	require.NoError(t, err)
+
+	mockRepo.On("List", repository.AuditFilter{}, repository.Page{Limit: 21}).Return([]repository.AuditEntry{}, nil).Once()
+	_, err = service.List(ctx, AuditQuery{}, PageRequest{Limit: 50})
+	require.NoError(t, err)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAuditService_List_Validation(t *testing.T) {
+	service := NewAuditService(new(MockAuditRepository), nil)
+	ctx := context.Background()
+	now := time.Now()
+
+	tests := []struct {
+		name          string
+		query         AuditQuery
+		pageRequest   PageRequest
+		expectedField string
+	}{
+		{name: "from after to", query: AuditQuery{From: now, To: now.Add(-time.Hour)}, expectedField: "from"},
+		{name: "empty range", query: AuditQuery{From: now, To: now}, expectedField: "from"},
+		{name: "negative customer id", query: AuditQuery{CustomerId: -1}, expectedField: "customerId"},
+		{name: "negative limit", pageRequest: PageRequest{Limit: -1}, expectedField: "limit"},
+		{name: "invalid cursor", pageRequest: PageRequest{Cursor: "!"}, expectedField: "cursor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.List(ctx, tt.query, tt.pageRequest)
+
+			var serviceErr *Error
+			require.ErrorAs(t, err, &serviceErr)
+			assert.Equal(t, CodeValidation, serviceErr.Code)
+			assert.Equal(t, tt.expectedField, serviceErr.Field)
+		})
+	}
+}
+
+func TestCustomerService_Delete_Audit(t *testing.T) {
+	mockRepo := new(MockCustomerRepository)
+	service := NewCustomerService(mockRepo)
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "admin", Method: auth.MethodJWT})
+
+	mockRepo.On("DeleteById", 5, repository.AuditInfo{Actor: "jwt:admin"}).Return(nil).Once()
+	require.NoError(t, service.DeleteById(ctx, 5))
+
+	// Callers that did not identify themselves are recorded as anonymous
+	mockRepo.On("DeleteById", 6, repository.AuditInfo{Actor: AnonymousActor}).Return(nil).Once()
+	require.NoError(t, service.DeleteById(context.Background(), 6))
+
+	mockRepo.AssertExpectations(t)
+}
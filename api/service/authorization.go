@@ -0,0 +1,43 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vlegro/backend/api/auth"
+	"github.com/vlegro/backend/api/repository"
+)
+
+// authorize fails with CodeForbidden unless the caller of ctx holds permission,
+// a nil policy allows everything.
+func authorize(ctx context.Context, policy *auth.Policy, permission auth.Permission) (auth.Grant, error) {
+	if policy == nil {
+		return auth.Grant{}, nil
+	}
+	grant := policy.GrantOf(ctx)
+	if !grant.Allows(permission) {
+		return auth.Grant{}, &Error{Code: CodeForbidden, Message: fmt.Sprintf("permission %s is required", permission)}
+	}
+	return grant, nil
+}
+
+// checkDeletePrefixes fails with CodeForbidden when the roles of grant restrict deletes to some
+// prefixes and filter could match customers outside of them. A nil filter stands for changes
+// by id, which restricted callers may not make.
+func checkDeletePrefixes(grant auth.Grant, filter *repository.PrefixFilter) error {
+	allowed, restricted := grant.DeletePrefixes()
+	if !restricted {
+		return nil
+	}
+	if filter == nil {
+		return &Error{Code: CodeForbidden, Message: "deletes are restricted to prefixes, use the prefix parameters instead of ids"}
+	}
+	if !filter.WithinPrefixes(allowed) {
+		return &Error{
+			Code:    CodeForbidden,
+			Message: fmt.Sprintf("deletes are restricted to exact matches of the prefixes %q", allowed),
+			Field:   "prefix",
+		}
+	}
+	return nil
+}
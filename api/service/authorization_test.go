@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/vlegro/backend/api/auth"
+	"github.com/vlegro/backend/api/repository"
+)
+
+func TestCustomerService_Authorization(t *testing.T) {
+	policy, err := auth.NewPolicy(map[string]auth.Role{
+		"reader":  {Permissions: []auth.Permission{auth.PermissionRead}},
+		"cleanup": {Permissions: []auth.Permission{auth.PermissionRead, auth.PermissionDelete}, DeletePrefixes: []string{"Тест"}},
+	}, nil)
+	require.NoError(t, err)
+	as := func(roles ...string) context.Context {
+		return auth.WithPrincipal(context.Background(), auth.Principal{Subject: "user", Method: auth.MethodJWT, Roles: roles})
+	}
+
+	mockRepo := new(MockCustomerRepository)
+	service := NewCustomerService(mockRepo, WithPolicy(policy))
+	auditService := NewAuditService(new(MockAuditRepository), policy)
+
+	mockRepo.On("GetById", 1).Return(repository.CustomerInfo{Id: 1}, nil).Once()
+	within := repository.PrefixFilter{Prefixes: []string{"Тестов"}}
+	mockRepo.On("DeleteByPrefix", within, false).Return(repository.DeleteInfo{Count: 1, Ids: []int{7}}, nil).Once()
+
+	tests := []struct {
+		name          string
+		call          func() error
+		expectedCode  Code
+		expectedField string
+	}{
+		{
+			name: "reader reads",
+			call: func() error {
+				_, err := service.GetById(as("reader"), 1)
+				return err
+			},
+		},
+		{
+			name: "reader cannot write",
+			call: func() error {
+				_, err := service.Create(as("reader"), repository.CustomerInfo{FirstName: strPtr("Клиент")})
+				return err
+			},
+			expectedCode: CodeForbidden,
+		},
+		{
+			name: "reader cannot delete",
+			call: func() error {
+				_, err := service.Delete(as("reader"), within, DeleteRequest{})
+				return err
+			},
+			expectedCode: CodeForbidden,
+		},
+		{
+			name: "anonymous callers have no roles",
+			call: func() error {
+				_, err := service.Get(context.Background(), within, PageRequest{})
+				return err
+			},
+			expectedCode: CodeForbidden,
+		},
+		{
+			name: "restricted delete within the prefixes",
+			call: func() error {
+				_, err := service.Delete(as("cleanup"), within, DeleteRequest{})
+				return err
+			},
+		},
+		{
+			name: "restricted delete outside the prefixes",
+			call: func() error {
+				_, err := service.Delete(as("cleanup"), repository.PrefixFilter{Prefixes: []string{"Тестов", "Клиент"}}, DeleteRequest{})
+				return err
+			},
+			expectedCode:  CodeForbidden,
+			expectedField: "prefix",
+		},
+		{
+			name:         "restricted delete by id",
+			call:         func() error { return service.DeleteById(as("cleanup"), 7) },
+			expectedCode: CodeForbidden,
+		},
+		{
+			name: "restricted restore by id",
+			call: func() error {
+				_, err := service.RestoreByIds(as("cleanup"), []int{7})
+				return err
+			},
+			expectedCode: CodeForbidden,
+		},
+		{
+			name: "audit requires its own permission",
+			call: func() error {
+				_, err := auditService.List(as("cleanup"), AuditQuery{}, PageRequest{})
+				return err
+			},
+			expectedCode: CodeForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+
+			if tt.expectedCode == "" {
+				require.NoError(t, err)
+				return
+			}
+			var serviceErr *Error
+			require.ErrorAs(t, err, &serviceErr)
+			assert.Equal(t, tt.expectedCode, serviceErr.Code)
+			assert.Equal(t, tt.expectedField, serviceErr.Field)
+		})
+	}
+
+	// Forbidden calls never reach the repository
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "DeleteById", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "Create", mock.Anything)
+}
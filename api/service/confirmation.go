@@ -0,0 +1,73 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// DefaultMaxDeleteCount is the number of customers a bulk delete may remove without confirmation.
+const DefaultMaxDeleteCount = 100
+
+// ConfirmationRequiredError is returned when a bulk delete matches more customers than allowed.
+// Repeating the request with Token confirms the deletion of exactly these Ids.
+type ConfirmationRequiredError struct {
+	Count int
+	Limit int
+	Ids   []int
+	Token string
+	// Changed is set when the request carried a token for a different set of customers.
+	Changed bool
+}
+
+func (e *ConfirmationRequiredError) Error() string {
+	if e.Changed {
+		return fmt.Sprintf("matched customers changed since confirmation, %d customers match now", e.Count)
+	}
+	return fmt.Sprintf("deleting %d customers exceeds the limit of %d, confirmation required", e.Count, e.Limit)
+}
+
+// confirmationToken signs the set of ids, it does not depend on their order.
+func (cs *CustomerService) confirmationToken(ids []int) string {
+	sorted := append([]int(nil), ids...)
+	sort.Ints(sorted)
+
+	mac := hmac.New(sha256.New, cs.confirmationSecret)
+	for _, id := range sorted {
+		mac.Write([]byte(strconv.Itoa(id)))
+		mac.Write([]byte{','})
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkDeleteCount lets the deletion through when it is within the limit or confirmed by token.
+func (cs *CustomerService) checkDeleteCount(ids []int, token string) error {
+	if cs.maxDeleteCount <= 0 || len(ids) <= cs.maxDeleteCount {
+		return nil
+	}
+
+	expected := cs.confirmationToken(ids)
+	if token != "" && hmac.Equal([]byte(token), []byte(expected)) {
+		return nil
+	}
+
+	return &ConfirmationRequiredError{
+		Count:   len(ids),
+		Limit:   cs.maxDeleteCount,
+		Ids:     ids,
+		Token:   expected,
+		Changed: token != "",
+	}
+}
+
+func randomSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Sprintf("failed to generate confirmation secret: %v", err))
+	}
+	return secret
+}
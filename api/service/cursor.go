@@ -0,0 +1,29 @@
+package service
+
+import (
+	"encoding/base64"
+	"strconv"
+)
+
+// encodeCursor turns the last id of a page into an opaque cursor for the next one.
+func encodeCursor(lastId int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(lastId)))
+}
+
+// decodeCursor returns the id to continue after, an empty cursor starts from the beginning.
+func decodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, validationError("cursor", "invalid cursor")
+	}
+	id, err := strconv.Atoi(string(raw))
+	if err != nil || id <= 0 {
+		return 0, validationError("cursor", "invalid cursor")
+	}
+
+	return id, nil
+}
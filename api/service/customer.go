@@ -1,55 +1,401 @@
 package service
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"strings"
 
+	"github.com/vlegro/backend/api/auth"
+	"github.com/vlegro/backend/api/logging"
 	"github.com/vlegro/backend/api/repository"
+	"github.com/vlegro/backend/api/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var tracer = otel.Tracer("github.com/vlegro/backend/api/service")
+
 type CustomerService struct {
 	customerRepository repository.CustomerRepository
+	maxDeleteCount     int
+	confirmationSecret []byte
+	defaultPageSize    int
+	maxPageSize        int
+	patternSearch      bool
+	policy             *auth.Policy
+}
+
+func NewCustomerService(customerRepository repository.CustomerRepository, options ...Option) *CustomerService {
+	cs := &CustomerService{
+		customerRepository: customerRepository,
+		maxDeleteCount:     DefaultMaxDeleteCount,
+		defaultPageSize:    DefaultPageSize,
+		maxPageSize:        MaxPageSize,
+		patternSearch:      true,
+	}
+	for _, option := range options {
+		option(cs)
+	}
+	if len(cs.confirmationSecret) == 0 {
+		cs.confirmationSecret = randomSecret()
+	}
+	return cs
+}
+
+const (
+	// DefaultPageSize is used when the caller does not ask for a limit.
+	DefaultPageSize = 50
+	// MaxPageSize caps the number of customers returned in a single page.
+	MaxPageSize = 100
+)
+
+// PageRequest asks for at most Limit customers following the opaque Cursor.
+type PageRequest struct {
+	Limit  int
+	Cursor string
 }
 
-func NewCustomerService(customerRepository repository.CustomerRepository) *CustomerService {
-	return &CustomerService{customerRepository: customerRepository}
+// CustomerPage is one page of customers, NextCursor is empty on the last page.
+type CustomerPage struct {
+	Customers  []repository.CustomerInfo `json:"customers"`
+	NextCursor string                    `json:"nextCursor,omitempty"`
 }
 
-func (cs *CustomerService) Get(prefix []string) ([]repository.CustomerInfo, error) {
+func (cs *CustomerService) Get(ctx context.Context, filter repository.PrefixFilter, pageRequest PageRequest) (_ CustomerPage, err error) {
+	ctx, span := tracer.Start(ctx, "CustomerService.Get")
+	defer tracing.End(span, &err)
+
+	// Check permissions
+	if _, err := authorize(ctx, cs.policy, auth.PermissionRead); err != nil {
+		return CustomerPage{}, err
+	}
+
 	// Validate input
-	if len(prefix) == 0 {
-		return nil, fmt.Errorf("prefix cannot be empty")
+	filter, err = cs.validateFilter(filter)
+	if err != nil {
+		return CustomerPage{}, err
+	}
+	if pageRequest.Limit < 0 {
+		return CustomerPage{}, validationError("limit", "limit cannot be negative")
+	}
+	afterId, err := decodeCursor(pageRequest.Cursor)
+	if err != nil {
+		return CustomerPage{}, err
 	}
 
-	// Get customers by prefix
-	customers, err := cs.customerRepository.GetByPrefix(prefix)
+	// Enforce the page size
+	limit := pageRequest.Limit
+	if limit == 0 {
+		limit = cs.defaultPageSize
+	}
+	if limit > cs.maxPageSize {
+		limit = cs.maxPageSize
+	}
+
+	// Get customers by prefix, one extra row tells whether there is a next page
+	customers, err := cs.customerRepository.GetByPrefix(ctx, filter, repository.Page{AfterId: afterId, Limit: limit + 1})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get customers: %w", err)
+		return CustomerPage{}, repositoryError(err)
+	}
+
+	page := CustomerPage{Customers: customers}
+	if len(customers) > limit {
+		page.Customers = customers[:limit]
+		page.NextCursor = encodeCursor(page.Customers[limit-1].Id)
+	}
+	if page.Customers == nil {
+		page.Customers = []repository.CustomerInfo{}
 	}
+	span.SetAttributes(attribute.Int("customers.count", len(page.Customers)))
 
-	return customers, nil
+	return page, nil
 }
 
-func (cs *CustomerService) Delete(prefix string) (repository.DeleteInfo, error) {
+// DeleteRequest carries the options of a bulk delete.
+type DeleteRequest struct {
+	// DryRun reports what would be deleted without committing anything.
+	DryRun bool
+	// ConfirmToken confirms a delete above the limit, see ConfirmationRequiredError.
+	ConfirmToken string
+}
+
+// Delete removes the customers matching filter. When more customers than the configured
+// limit match, nothing is deleted and a *ConfirmationRequiredError carries the token
+// that confirms this exact set of customers. A dry run above the limit returns the token
+// in DeleteInfo.ConfirmToken.
+func (cs *CustomerService) Delete(ctx context.Context, filter repository.PrefixFilter, request DeleteRequest) (_ repository.DeleteInfo, err error) {
+	ctx, span := tracer.Start(ctx, "CustomerService.Delete")
+	defer tracing.End(span, &err)
+
+	// Check permissions
+	grant, err := authorize(ctx, cs.policy, auth.PermissionDelete)
+	if err != nil {
+		return repository.DeleteInfo{}, err
+	}
+
 	// Validate input
-	if prefix == "" {
-		return repository.DeleteInfo{}, fmt.Errorf("prefix cannot be empty")
+	filter, err = cs.validateFilter(filter)
+	if err != nil {
+		return repository.DeleteInfo{}, err
+	}
+	if err := checkDeletePrefixes(grant, &filter); err != nil {
+		return repository.DeleteInfo{}, err
 	}
 
-	// Split prefix by comma and trim spaces
-	prefixes := strings.Split(prefix, ",")
-	for i := range prefixes {
-		prefixes[i] = strings.TrimSpace(prefixes[i])
-		if prefixes[i] == "" {
-			return repository.DeleteInfo{}, fmt.Errorf("invalid empty prefix in the list")
+	// Delete customers by prefix, the count is checked against the limit before anything is deleted
+	options := repository.DeleteOptions{DryRun: request.DryRun, Audit: auditInfo(ctx)}
+	if !request.DryRun {
+		options.Check = func(ids []int) error {
+			return cs.checkDeleteCount(ids, request.ConfirmToken)
 		}
 	}
 
-	// Delete customers by prefix
-	deleteInfo, err := cs.customerRepository.DeleteByPrefix(prefixes)
+	deleteInfo, err := cs.customerRepository.DeleteByPrefix(ctx, filter, options)
+	var confirmationErr *ConfirmationRequiredError
+	if errors.As(err, &confirmationErr) {
+		return repository.DeleteInfo{}, confirmationErr
+	}
 	if err != nil {
-		return repository.DeleteInfo{}, fmt.Errorf("failed to delete customers: %w", err)
+		return repository.DeleteInfo{}, repositoryError(err)
+	}
+
+	if request.DryRun && cs.maxDeleteCount > 0 && deleteInfo.Count > cs.maxDeleteCount {
+		deleteInfo.ConfirmToken = cs.confirmationToken(deleteInfo.Ids)
+	}
+	span.SetAttributes(attribute.Int("customers.count", deleteInfo.Count), attribute.Bool("customers.dry_run", request.DryRun))
+	if !request.DryRun {
+		logging.FromContext(ctx).Info("Customers deleted", "count", deleteInfo.Count, "ids", deleteInfo.Ids)
 	}
 
 	return deleteInfo, nil
-}
\ No newline at end of file
+}
+
+// Create stores a new customer and returns it with the id generated by the database.
+func (cs *CustomerService) Create(ctx context.Context, customer repository.CustomerInfo) (_ repository.CustomerInfo, err error) {
+	ctx, span := tracer.Start(ctx, "CustomerService.Create")
+	defer tracing.End(span, &err)
+
+	// Check permissions
+	if _, err := authorize(ctx, cs.policy, auth.PermissionWrite); err != nil {
+		return repository.CustomerInfo{}, err
+	}
+
+	// Validate input
+	trimCustomer(&customer)
+	if err := validateCustomer(customer, nil); err != nil {
+		return repository.CustomerInfo{}, err
+	}
+
+	// Ids are always generated, never taken from the caller
+	customer.Id = 0
+
+	created, err := cs.customerRepository.Create(ctx, customer)
+	if err != nil {
+		return repository.CustomerInfo{}, repositoryError(err)
+	}
+
+	return created, nil
+}
+
+func (cs *CustomerService) GetById(ctx context.Context, id int) (_ repository.CustomerInfo, err error) {
+	ctx, span := tracer.Start(ctx, "CustomerService.GetById")
+	defer tracing.End(span, &err)
+
+	// Check permissions
+	if _, err := authorize(ctx, cs.policy, auth.PermissionRead); err != nil {
+		return repository.CustomerInfo{}, err
+	}
+
+	// Validate input
+	if id <= 0 {
+		return repository.CustomerInfo{}, validationError("id", "id must be a positive number")
+	}
+
+	customer, err := cs.customerRepository.GetById(ctx, id)
+	if err != nil {
+		return repository.CustomerInfo{}, repositoryError(err)
+	}
+
+	return customer, nil
+}
+
+// Update replaces all fields of the customer with the given id.
+func (cs *CustomerService) Update(ctx context.Context, id int, customer repository.CustomerInfo) (_ repository.CustomerInfo, err error) {
+	ctx, span := tracer.Start(ctx, "CustomerService.Update")
+	defer tracing.End(span, &err)
+
+	// Check permissions
+	if _, err := authorize(ctx, cs.policy, auth.PermissionWrite); err != nil {
+		return repository.CustomerInfo{}, err
+	}
+
+	// Validate input, the fields are checked against the stored customer once it is locked
+	if id <= 0 {
+		return repository.CustomerInfo{}, validationError("id", "id must be a positive number")
+	}
+	trimCustomer(&customer)
+
+	customer.Id = id
+	updated, err := cs.customerRepository.Update(ctx, customer, writeOptions(customer))
+	if err != nil {
+		return repository.CustomerInfo{}, repositoryError(err)
+	}
+
+	return updated, nil
+}
+
+// Patch updates only the fields set in patch for the customer with the given id.
+func (cs *CustomerService) Patch(ctx context.Context, id int, patch repository.CustomerInfo) (_ repository.CustomerInfo, err error) {
+	ctx, span := tracer.Start(ctx, "CustomerService.Patch")
+	defer tracing.End(span, &err)
+
+	// Check permissions
+	if _, err := authorize(ctx, cs.policy, auth.PermissionWrite); err != nil {
+		return repository.CustomerInfo{}, err
+	}
+
+	// Validate input, the fields are checked against the stored customer once it is locked
+	if id <= 0 {
+		return repository.CustomerInfo{}, validationError("id", "id must be a positive number")
+	}
+	trimCustomer(&patch)
+
+	patched, err := cs.customerRepository.Patch(ctx, id, patch, writeOptions(patch))
+	if err != nil {
+		return repository.CustomerInfo{}, repositoryError(err)
+	}
+
+	return patched, nil
+}
+
+// writeOptions validates the fields of customer that differ from the stored customer, which the
+// repository locks until the write commits.
+func writeOptions(customer repository.CustomerInfo) repository.WriteOptions {
+	return repository.WriteOptions{
+		Check: func(current repository.CustomerInfo) error {
+			return validateCustomer(customer, &current)
+		},
+	}
+}
+
+func (cs *CustomerService) DeleteById(ctx context.Context, id int) (err error) {
+	ctx, span := tracer.Start(ctx, "CustomerService.DeleteById")
+	defer tracing.End(span, &err)
+
+	// Check permissions
+	grant, err := authorize(ctx, cs.policy, auth.PermissionDelete)
+	if err != nil {
+		return err
+	}
+	if err := checkDeletePrefixes(grant, nil); err != nil {
+		return err
+	}
+
+	// Validate input
+	if id <= 0 {
+		return validationError("id", "id must be a positive number")
+	}
+
+	if err := cs.customerRepository.DeleteById(ctx, id, auditInfo(ctx)); err != nil {
+		return repositoryError(err)
+	}
+	logging.FromContext(ctx).Info("Customer deleted", "id", id)
+
+	return nil
+}
+
+// RestoreByIds brings back deleted customers by id.
+func (cs *CustomerService) RestoreByIds(ctx context.Context, ids []int) (_ repository.DeleteInfo, err error) {
+	ctx, span := tracer.Start(ctx, "CustomerService.RestoreByIds")
+	defer tracing.End(span, &err)
+
+	// Check permissions
+	grant, err := authorize(ctx, cs.policy, auth.PermissionDelete)
+	if err != nil {
+		return repository.DeleteInfo{}, err
+	}
+	if err := checkDeletePrefixes(grant, nil); err != nil {
+		return repository.DeleteInfo{}, err
+	}
+
+	// Validate input
+	if len(ids) == 0 {
+		return repository.DeleteInfo{}, validationError("ids", "ids cannot be empty")
+	}
+	for _, id := range ids {
+		if id <= 0 {
+			return repository.DeleteInfo{}, validationError("ids", "id must be a positive number")
+		}
+	}
+
+	restoreInfo, err := cs.customerRepository.RestoreByIds(ctx, ids)
+	if err != nil {
+		return repository.DeleteInfo{}, repositoryError(err)
+	}
+	span.SetAttributes(attribute.Int("customers.count", restoreInfo.Count))
+	logging.FromContext(ctx).Info("Customers restored", "count", restoreInfo.Count, "ids", restoreInfo.Ids)
+
+	return restoreInfo, nil
+}
+
+// RestoreByPrefix brings back the deleted customers matching filter.
+func (cs *CustomerService) RestoreByPrefix(ctx context.Context, filter repository.PrefixFilter) (_ repository.DeleteInfo, err error) {
+	ctx, span := tracer.Start(ctx, "CustomerService.RestoreByPrefix")
+	defer tracing.End(span, &err)
+
+	// Check permissions
+	grant, err := authorize(ctx, cs.policy, auth.PermissionDelete)
+	if err != nil {
+		return repository.DeleteInfo{}, err
+	}
+
+	// Validate input
+	filter, err = cs.validateFilter(filter)
+	if err != nil {
+		return repository.DeleteInfo{}, err
+	}
+	if err := checkDeletePrefixes(grant, &filter); err != nil {
+		return repository.DeleteInfo{}, err
+	}
+
+	restoreInfo, err := cs.customerRepository.RestoreByPrefix(ctx, filter)
+	if err != nil {
+		return repository.DeleteInfo{}, repositoryError(err)
+	}
+	span.SetAttributes(attribute.Int("customers.count", restoreInfo.Count))
+	logging.FromContext(ctx).Info("Customers restored", "count", restoreInfo.Count, "ids", restoreInfo.Ids)
+
+	return restoreInfo, nil
+}
+
+// validateFilter trims the prefixes and rejects empty ones, unknown fields and match modes,
+// and wildcards when pattern search is disabled.
+func (cs *CustomerService) validateFilter(filter repository.PrefixFilter) (repository.PrefixFilter, error) {
+	if len(filter.Prefixes) == 0 {
+		return repository.PrefixFilter{}, validationError("prefix", "prefix cannot be empty")
+	}
+	if filter.Pattern && !cs.patternSearch {
+		return repository.PrefixFilter{}, validationError("pattern", "pattern search is disabled")
+	}
+
+	prefixes := make([]string, len(filter.Prefixes))
+	for i, prefix := range filter.Prefixes {
+		prefixes[i] = strings.TrimSpace(prefix)
+		if prefixes[i] == "" {
+			return repository.PrefixFilter{}, validationError("prefix", "invalid empty prefix in the list")
+		}
+	}
+	for _, field := range filter.Fields {
+		if _, err := repository.ParseField(string(field)); err != nil {
+			return repository.PrefixFilter{}, validationError("field", err.Error())
+		}
+	}
+	if filter.Mode != "" {
+		if _, err := repository.ParseMatchMode(string(filter.Mode)); err != nil {
+			return repository.PrefixFilter{}, validationError("match", err.Error())
+		}
+	}
+
+	filter.Prefixes = prefixes
+	return filter, nil
+}
@@ -1,46 +1,113 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"github.com/vlegro/backend/api/repository"
 )
 
 // MockCustomerRepository is a mock implementation of CustomerRepository
 type MockCustomerRepository struct {
 	mock.Mock
+	// stored holds the customers the checks of Update and Patch see, unknown ids are empty
+	stored map[int]repository.CustomerInfo
 }
 
-func (m *MockCustomerRepository) GetByPrefix(prefix []string) ([]repository.CustomerInfo, error) {
-	args := m.Called(prefix)
+func (m *MockCustomerRepository) GetByPrefix(ctx context.Context, filter repository.PrefixFilter, page repository.Page) ([]repository.CustomerInfo, error) {
+	args := m.Called(filter, page)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).([]repository.CustomerInfo), args.Error(1)
 }
 
-func (m *MockCustomerRepository) DeleteByPrefix(prefix []string) (repository.DeleteInfo, error) {
-	args := m.Called(prefix)
+func (m *MockCustomerRepository) DeleteByPrefix(ctx context.Context, filter repository.PrefixFilter, options repository.DeleteOptions) (repository.DeleteInfo, error) {
+	args := m.Called(filter, options.DryRun)
+	deleteInfo := args.Get(0).(repository.DeleteInfo)
+
+	// Run the check on the matched ids like the real repository does before deleting
+	if options.Check != nil && deleteInfo.Count > 0 {
+		if err := options.Check(deleteInfo.Ids); err != nil {
+			return repository.DeleteInfo{}, err
+		}
+	}
+	return deleteInfo, args.Error(1)
+}
+
+func (m *MockCustomerRepository) Create(ctx context.Context, customer repository.CustomerInfo) (repository.CustomerInfo, error) {
+	args := m.Called(customer)
+	return args.Get(0).(repository.CustomerInfo), args.Error(1)
+}
+
+func (m *MockCustomerRepository) GetById(ctx context.Context, id int) (repository.CustomerInfo, error) {
+	args := m.Called(id)
+	return args.Get(0).(repository.CustomerInfo), args.Error(1)
+}
+
+func (m *MockCustomerRepository) Update(ctx context.Context, customer repository.CustomerInfo, options repository.WriteOptions) (repository.CustomerInfo, error) {
+	if err := m.check(customer.Id, options); err != nil {
+		return repository.CustomerInfo{}, err
+	}
+	args := m.Called(customer)
+	return args.Get(0).(repository.CustomerInfo), args.Error(1)
+}
+
+func (m *MockCustomerRepository) Patch(ctx context.Context, id int, patch repository.CustomerInfo, options repository.WriteOptions) (repository.CustomerInfo, error) {
+	if err := m.check(id, options); err != nil {
+		return repository.CustomerInfo{}, err
+	}
+	args := m.Called(id, patch)
+	return args.Get(0).(repository.CustomerInfo), args.Error(1)
+}
+
+// check runs the check on the stored customer like the real repository does before writing.
+func (m *MockCustomerRepository) check(id int, options repository.WriteOptions) error {
+	if options.Check == nil {
+		return nil
+	}
+	return options.Check(m.stored[id])
+}
+
+func (m *MockCustomerRepository) DeleteById(ctx context.Context, id int, audit repository.AuditInfo) error {
+	args := m.Called(id, audit)
+	return args.Error(0)
+}
+
+func (m *MockCustomerRepository) RestoreByIds(ctx context.Context, ids []int) (repository.DeleteInfo, error) {
+	args := m.Called(ids)
+	return args.Get(0).(repository.DeleteInfo), args.Error(1)
+}
+
+func (m *MockCustomerRepository) RestoreByPrefix(ctx context.Context, filter repository.PrefixFilter) (repository.DeleteInfo, error) {
+	args := m.Called(filter)
 	return args.Get(0).(repository.DeleteInfo), args.Error(1)
 }
 
 func TestCustomerService_Get(t *testing.T) {
 	mockRepo := new(MockCustomerRepository)
+	ctx := context.Background()
 	service := NewCustomerService(mockRepo)
 
 	tests := []struct {
 		name           string
-		prefix         []string
+		filter         repository.PrefixFilter
+		pageRequest    PageRequest
+		expectedPage   repository.Page
 		mockReturn     []repository.CustomerInfo
 		mockError      error
-		expectedResult []repository.CustomerInfo
+		expectedResult CustomerPage
 		expectedError  bool
 	}{
 		{
-			name:   "successful get",
-			prefix: []string{"Клиент"},
+			name:         "successful get",
+			filter:       repository.PrefixFilter{Prefixes: []string{"Клиент"}},
+			expectedPage: repository.Page{Limit: DefaultPageSize + 1},
 			mockReturn: []repository.CustomerInfo{
 				{
 					Id:        1,
@@ -49,26 +116,73 @@ func TestCustomerService_Get(t *testing.T) {
 				},
 			},
 			mockError:      nil,
-			expectedResult: []repository.CustomerInfo{{Id: 1, FirstName: strPtr("Клиент1"), LastName: strPtr("Клиентов1")}},
+			expectedResult: CustomerPage{Customers: []repository.CustomerInfo{{Id: 1, FirstName: strPtr("Клиент1"), LastName: strPtr("Клиентов1")}}},
 			expectedError:  false,
 		},
+		{
+			name:         "page with next cursor",
+			filter:       repository.PrefixFilter{Prefixes: []string{"Другой"}, Fields: []repository.Field{repository.FieldFirstName, repository.FieldLastName}},
+			pageRequest:  PageRequest{Limit: 2, Cursor: encodeCursor(1)},
+			expectedPage: repository.Page{AfterId: 1, Limit: 3},
+			mockReturn: []repository.CustomerInfo{
+				{Id: 2, FirstName: strPtr("Другой2")},
+				{Id: 3, FirstName: strPtr("Другой3")},
+				{Id: 4, FirstName: strPtr("Другой4")},
+			},
+			expectedResult: CustomerPage{
+				Customers: []repository.CustomerInfo{
+					{Id: 2, FirstName: strPtr("Другой2")},
+					{Id: 3, FirstName: strPtr("Другой3")},
+				},
+				NextCursor: encodeCursor(3),
+			},
+		},
+		{
+			name:           "limit is capped",
+			filter:         repository.PrefixFilter{Prefixes: []string{"Ещё"}},
+			pageRequest:    PageRequest{Limit: MaxPageSize * 10},
+			expectedPage:   repository.Page{Limit: MaxPageSize + 1},
+			mockReturn:     nil,
+			expectedResult: CustomerPage{Customers: []repository.CustomerInfo{}},
+		},
+		{
+			name:          "invalid cursor",
+			filter:        repository.PrefixFilter{Prefixes: []string{"Клиент"}},
+			pageRequest:   PageRequest{Cursor: "not a cursor"},
+			expectedError: true,
+		},
+		{
+			name:          "empty prefix in the list",
+			filter:        repository.PrefixFilter{Prefixes: []string{"Клиент", " "}},
+			expectedError: true,
+		},
+		{
+			name:          "unknown field",
+			filter:        repository.PrefixFilter{Prefixes: []string{"Клиент"}, Fields: []repository.Field{"password"}},
+			expectedError: true,
+		},
+		{
+			name:          "unknown match mode",
+			filter:        repository.PrefixFilter{Prefixes: []string{"Клиент"}, Mode: "soundex"},
+			expectedError: true,
+		},
 		{
 			name:           "empty prefix",
-			prefix:         []string{},
+			filter:         repository.PrefixFilter{},
 			mockReturn:     nil,
 			mockError:      nil,
-			expectedResult: nil,
+			expectedResult: CustomerPage{},
 			expectedError:  true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if len(tt.prefix) > 0 {
-				mockRepo.On("GetByPrefix", tt.prefix).Return(tt.mockReturn, tt.mockError)
+			if !tt.expectedError {
+				mockRepo.On("GetByPrefix", tt.filter, tt.expectedPage).Return(tt.mockReturn, tt.mockError)
 			}
 
-			result, err := service.Get(tt.prefix)
+			result, err := service.Get(ctx, tt.filter, tt.pageRequest)
 
 			if tt.expectedError {
 				assert.Error(t, err)
@@ -83,27 +197,42 @@ func TestCustomerService_Get(t *testing.T) {
 
 func TestCustomerService_Delete(t *testing.T) {
 	mockRepo := new(MockCustomerRepository)
+	ctx := context.Background()
 	service := NewCustomerService(mockRepo)
 
 	tests := []struct {
 		name           string
-		prefix         string
+		filter         repository.PrefixFilter
+		request        DeleteRequest
+		expectedFilter repository.PrefixFilter
+		expectedDryRun bool
 		mockReturn     repository.DeleteInfo
 		mockError      error
 		expectedResult repository.DeleteInfo
 		expectedError  bool
 	}{
 		{
-			name:           "successful delete",
-			prefix:         "Клиент",
+			name:   "successful delete",
+			filter: repository.PrefixFilter{Prefixes: []string{" Клиент "}, Fields: []repository.Field{repository.FieldEmail}},
+			// Prefixes reach the repository trimmed
+			expectedFilter: repository.PrefixFilter{Prefixes: []string{"Клиент"}, Fields: []repository.Field{repository.FieldEmail}},
 			mockReturn:     repository.DeleteInfo{Count: 1, Ids: []int{1}},
 			mockError:      nil,
 			expectedResult: repository.DeleteInfo{Count: 1, Ids: []int{1}},
 			expectedError:  false,
 		},
+		{
+			name:           "dry run",
+			filter:         repository.PrefixFilter{Prefixes: []string{"Другой"}},
+			request:        DeleteRequest{DryRun: true},
+			expectedFilter: repository.PrefixFilter{Prefixes: []string{"Другой"}},
+			expectedDryRun: true,
+			mockReturn:     repository.DeleteInfo{Count: 1, Ids: []int{5}, Names: []string{"Клиентов5 ДругойКлиент5 Клиентович5"}, DryRun: true},
+			expectedResult: repository.DeleteInfo{Count: 1, Ids: []int{5}, Names: []string{"Клиентов5 ДругойКлиент5 Клиентович5"}, DryRun: true},
+		},
 		{
 			name:           "empty prefix",
-			prefix:         "",
+			filter:         repository.PrefixFilter{},
 			mockReturn:     repository.DeleteInfo{},
 			mockError:      nil,
 			expectedResult: repository.DeleteInfo{},
@@ -113,11 +242,11 @@ func TestCustomerService_Delete(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if tt.prefix != "" {
-				mockRepo.On("DeleteByPrefix", []string{tt.prefix}).Return(tt.mockReturn, tt.mockError)
+			if !tt.expectedError {
+				mockRepo.On("DeleteByPrefix", tt.expectedFilter, tt.expectedDryRun).Return(tt.mockReturn, tt.mockError)
 			}
 
-			result, err := service.Delete(tt.prefix)
+			result, err := service.Delete(ctx, tt.filter, tt.request)
 
 			if tt.expectedError {
 				assert.Error(t, err)
@@ -130,6 +259,264 @@ func TestCustomerService_Delete(t *testing.T) {
 	}
 }
 
+func TestCustomerService_Delete_Confirmation(t *testing.T) {
+	mockRepo := new(MockCustomerRepository)
+	ctx := context.Background()
+	service := NewCustomerService(mockRepo, WithMaxDeleteCount(2), WithConfirmationSecret([]byte("secret")))
+
+	filter := repository.PrefixFilter{Prefixes: []string{"Клиент"}}
+	matched := repository.DeleteInfo{Count: 3, Ids: []int{1, 2, 3}}
+
+	// A dry run above the limit hands out the token
+	mockRepo.On("DeleteByPrefix", filter, true).Return(matched, nil).Once()
+	preview, err := service.Delete(ctx, filter, DeleteRequest{DryRun: true})
+	require.NoError(t, err)
+	assert.NotEmpty(t, preview.ConfirmToken)
+
+	// Without the token nothing is deleted
+	mockRepo.On("DeleteByPrefix", filter, false).Return(matched, nil).Once()
+	_, err = service.Delete(ctx, filter, DeleteRequest{})
+	var confirmationErr *ConfirmationRequiredError
+	require.ErrorAs(t, err, &confirmationErr)
+	assert.Equal(t, 3, confirmationErr.Count)
+	assert.Equal(t, preview.ConfirmToken, confirmationErr.Token)
+	assert.False(t, confirmationErr.Changed)
+
+	// The token only confirms the set it was issued for
+	mockRepo.On("DeleteByPrefix", filter, false).Return(repository.DeleteInfo{Count: 3, Ids: []int{1, 2, 4}}, nil).Once()
+	_, err = service.Delete(ctx, filter, DeleteRequest{ConfirmToken: preview.ConfirmToken})
+	require.ErrorAs(t, err, &confirmationErr)
+	assert.True(t, confirmationErr.Changed)
+
+	// The matching token lets the delete through
+	mockRepo.On("DeleteByPrefix", filter, false).Return(matched, nil).Once()
+	result, err := service.Delete(ctx, filter, DeleteRequest{ConfirmToken: preview.ConfirmToken})
+	require.NoError(t, err)
+	assert.Equal(t, matched, result)
+
+	// Deletes within the limit need no token
+	small := repository.DeleteInfo{Count: 2, Ids: []int{1, 2}}
+	mockRepo.On("DeleteByPrefix", filter, false).Return(small, nil).Once()
+	result, err = service.Delete(ctx, filter, DeleteRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, small, result)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCustomerService_Restore(t *testing.T) {
+	mockRepo := new(MockCustomerRepository)
+	ctx := context.Background()
+	service := NewCustomerService(mockRepo)
+
+	mockRepo.On("RestoreByIds", []int{1, 2}).Return(repository.DeleteInfo{Count: 2, Ids: []int{1, 2}}, nil)
+	result, err := service.RestoreByIds(ctx, []int{1, 2})
+	require.NoError(t, err)
+	assert.Equal(t, repository.DeleteInfo{Count: 2, Ids: []int{1, 2}}, result)
+
+	filter := repository.PrefixFilter{Prefixes: []string{"Другой"}}
+	mockRepo.On("RestoreByPrefix", filter).Return(repository.DeleteInfo{Count: 1, Ids: []int{5}}, nil)
+	result, err = service.RestoreByPrefix(ctx, filter)
+	require.NoError(t, err)
+	assert.Equal(t, repository.DeleteInfo{Count: 1, Ids: []int{5}}, result)
+
+	_, err = service.RestoreByIds(ctx, nil)
+	assert.Error(t, err)
+	_, err = service.RestoreByIds(ctx, []int{0})
+	assert.Error(t, err)
+	_, err = service.RestoreByPrefix(ctx, repository.PrefixFilter{})
+	assert.Error(t, err)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCustomerService_GetById(t *testing.T) {
+	mockRepo := new(MockCustomerRepository)
+	ctx := context.Background()
+	service := NewCustomerService(mockRepo)
+
+	tests := []struct {
+		name           string
+		id             int
+		mockReturn     repository.CustomerInfo
+		mockError      error
+		expectedResult repository.CustomerInfo
+		expectedError  error
+	}{
+		{
+			name:           "successful get",
+			id:             1,
+			mockReturn:     repository.CustomerInfo{Id: 1, FirstName: strPtr("Клиент1")},
+			expectedResult: repository.CustomerInfo{Id: 1, FirstName: strPtr("Клиент1")},
+		},
+		{
+			name:          "not found",
+			id:            42,
+			mockReturn:    repository.CustomerInfo{},
+			mockError:     repository.ErrNotFound,
+			expectedError: repository.ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo.On("GetById", tt.id).Return(tt.mockReturn, tt.mockError)
+
+			result, err := service.GetById(ctx, tt.id)
+
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedResult, result)
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+
+	t.Run("invalid id", func(t *testing.T) {
+		_, err := service.GetById(ctx, 0)
+		assert.Error(t, err)
+	})
+}
+
+func TestCustomerService_Create(t *testing.T) {
+	mockRepo := new(MockCustomerRepository)
+	ctx := context.Background()
+	service := NewCustomerService(mockRepo)
+
+	// A caller supplied id is dropped, the repository returns the generated one
+	mockRepo.On("Create", repository.CustomerInfo{FirstName: strPtr("Шестой")}).
+		Return(repository.CustomerInfo{Id: 6, FirstName: strPtr("Шестой")}, nil)
+
+	result, err := service.Create(ctx, repository.CustomerInfo{Id: 1, FirstName: strPtr("Шестой")})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 6, result.Id)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCustomerService_Update(t *testing.T) {
+	mockRepo := new(MockCustomerRepository)
+	ctx := context.Background()
+	service := NewCustomerService(mockRepo)
+
+	// The id from the path wins over the one in the body
+	expected := repository.CustomerInfo{Id: 3, FirstName: strPtr("Третий")}
+	mockRepo.On("Update", expected).Return(expected, nil)
+
+	result, err := service.Update(ctx, 3, repository.CustomerInfo{Id: 7, FirstName: strPtr("Третий")})
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, result)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCustomerService_ErrorCodes(t *testing.T) {
+	mockRepo := new(MockCustomerRepository)
+	ctx := context.Background()
+	service := NewCustomerService(mockRepo)
+
+	mockRepo.On("GetById", 404).Return(repository.CustomerInfo{}, repository.ErrNotFound)
+	mockRepo.On("Create", repository.CustomerInfo{FirstName: strPtr("Первый")}).
+		Return(repository.CustomerInfo{}, repository.ErrAlreadyExists)
+	mockRepo.On("DeleteById", 500, repository.AuditInfo{Actor: AnonymousActor}).
+		Return(fmt.Errorf("failed to delete customer: %w", errors.New("connection reset")))
+
+	tests := []struct {
+		name          string
+		call          func() error
+		expectedCode  Code
+		expectedField string
+	}{
+		{
+			name: "empty prefix",
+			call: func() error {
+				_, err := service.Get(ctx, repository.PrefixFilter{}, PageRequest{})
+				return err
+			},
+			expectedCode:  CodeValidation,
+			expectedField: "prefix",
+		},
+		{
+			name: "invalid cursor",
+			call: func() error {
+				_, err := service.Get(ctx, repository.PrefixFilter{Prefixes: []string{"Кл"}}, PageRequest{Cursor: "!"})
+				return err
+			},
+			expectedCode:  CodeValidation,
+			expectedField: "cursor",
+		},
+		{
+			name: "unknown field",
+			call: func() error {
+				_, err := service.Delete(ctx, repository.PrefixFilter{Prefixes: []string{"Кл"}, Fields: []repository.Field{"age"}}, DeleteRequest{})
+				return err
+			},
+			expectedCode:  CodeValidation,
+			expectedField: "field",
+		},
+		{
+			name: "not found",
+			call: func() error {
+				_, err := service.GetById(ctx, 404)
+				return err
+			},
+			expectedCode: CodeNotFound,
+		},
+		{
+			name: "conflict",
+			call: func() error {
+				_, err := service.Create(ctx, repository.CustomerInfo{FirstName: strPtr("Первый")})
+				return err
+			},
+			expectedCode: CodeConflict,
+		},
+		{
+			name:         "internal",
+			call:         func() error { return service.DeleteById(ctx, 500) },
+			expectedCode: CodeInternal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+
+			var serviceErr *Error
+			require.ErrorAs(t, err, &serviceErr)
+			assert.Equal(t, tt.expectedCode, serviceErr.Code)
+			assert.Equal(t, tt.expectedField, serviceErr.Field)
+			assert.Equal(t, tt.expectedCode, ErrorCode(err))
+		})
+	}
+
+	// The repository already names the failed operation
+	assert.EqualError(t, service.DeleteById(ctx, 500), "failed to delete customer: connection reset")
+}
+
+func TestCustomerService_Options(t *testing.T) {
+	mockRepo := new(MockCustomerRepository)
+	ctx := context.Background()
+	service := NewCustomerService(mockRepo, WithPageSizes(10, 20), WithPatternSearch(false))
+	filter := repository.PrefixFilter{Prefixes: []string{"Кл"}}
+
+	mockRepo.On("GetByPrefix", filter, repository.Page{Limit: 11}).Return([]repository.CustomerInfo{}, nil).Once()
+	_, err := service.Get(ctx, filter, PageRequest{})
+	require.NoError(t, err)
+
+	mockRepo.On("GetByPrefix", filter, repository.Page{Limit: 21}).Return([]repository.CustomerInfo{}, nil).Once()
+	_, err = service.Get(ctx, filter, PageRequest{Limit: 50})
+	require.NoError(t, err)
+
+	_, err = service.Get(ctx, repository.PrefixFilter{Prefixes: []string{"Кл%"}, Pattern: true}, PageRequest{})
+	var serviceErr *Error
+	require.ErrorAs(t, err, &serviceErr)
+	assert.Equal(t, "pattern", serviceErr.Field)
+
+	mockRepo.AssertExpectations(t)
+}
+
 func strPtr(s string) *string {
 	return &s
 }
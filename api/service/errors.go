@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/vlegro/backend/api/repository"
+)
+
+// Code is the stable category of an Error, API clients may rely on it.
+type Code string
+
+const (
+	CodeValidation Code = "validation_error"
+	// CodeInvalidFields is a body that decoded but holds invalid values, listed in Error.Fields.
+	CodeInvalidFields Code = "invalid_fields"
+	CodeForbidden     Code = "forbidden"
+	CodeNotFound      Code = "not_found"
+	CodeConflict      Code = "conflict"
+	CodeTimeout       Code = "timeout"
+	CodeInternal      Code = "internal_error"
+)
+
+// Error is returned by CustomerService for every failure, apart from *ConfirmationRequiredError.
+type Error struct {
+	Code Code
+	// Message is shown to clients, it is empty when Err alone describes an internal failure.
+	Message string
+	// Field names the offending input, it is empty when the error is not about a single field.
+	Field string
+	// Fields lists every invalid field of a CodeInvalidFields error.
+	Fields []FieldError
+	Err    error
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Err == nil:
+		return e.Message
+	case e.Message == "":
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Message, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCode returns the Code of err, errors that are not an *Error are internal.
+func ErrorCode(err error) Code {
+	var serviceErr *Error
+	if errors.As(err, &serviceErr) {
+		return serviceErr.Code
+	}
+	return CodeInternal
+}
+
+func validationError(field, message string) error {
+	return &Error{Code: CodeValidation, Message: message, Field: field}
+}
+
+// repositoryError classifies an error of the repository, which already names the failed operation.
+// Errors of the checks the service hands to the repository are returned as is.
+func repositoryError(err error) error {
+	var serviceErr *Error
+	switch {
+	case errors.As(err, &serviceErr):
+		return serviceErr
+	case errors.Is(err, repository.ErrNotFound):
+		return &Error{Code: CodeNotFound, Message: "customer not found", Err: err}
+	case errors.Is(err, repository.ErrAlreadyExists):
+		return &Error{Code: CodeConflict, Message: "customer already exists", Err: err}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Code: CodeTimeout, Message: "request timed out", Err: err}
+	default:
+		return &Error{Code: CodeInternal, Err: err}
+	}
+}
@@ -0,0 +1,45 @@
+package service
+
+import "github.com/vlegro/backend/api/auth"
+
+// Option configures a CustomerService.
+type Option func(*CustomerService)
+
+// WithMaxDeleteCount sets how many customers a bulk delete may remove without confirmation.
+// Zero or a negative value disables the limit.
+func WithMaxDeleteCount(maxDeleteCount int) Option {
+	return func(cs *CustomerService) {
+		cs.maxDeleteCount = maxDeleteCount
+	}
+}
+
+// WithConfirmationSecret sets the key used to sign delete confirmation tokens.
+// Instances behind the same load balancer must share it.
+func WithConfirmationSecret(secret []byte) Option {
+	return func(cs *CustomerService) {
+		cs.confirmationSecret = secret
+	}
+}
+
+// WithPageSizes replaces DefaultPageSize and MaxPageSize.
+func WithPageSizes(defaultSize, maxSize int) Option {
+	return func(cs *CustomerService) {
+		cs.defaultPageSize = defaultSize
+		cs.maxPageSize = maxSize
+	}
+}
+
+// WithPatternSearch allows or rejects filters with LIKE wildcards, they are allowed by default.
+func WithPatternSearch(enabled bool) Option {
+	return func(cs *CustomerService) {
+		cs.patternSearch = enabled
+	}
+}
+
+// WithPolicy enforces the permissions of the caller on every operation, without a policy
+// every caller may do everything.
+func WithPolicy(policy *auth.Policy) Option {
+	return func(cs *CustomerService) {
+		cs.policy = policy
+	}
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/vlegro/backend/api/repository"
+)
+
+// The column sizes of the customer table, in characters.
+const (
+	maxNameLength  = 100
+	maxEmailLength = 200
+)
+
+// e164 is an international phone number: a plus, the country code and up to 15 digits in total,
+// so it always fits the phone column of 20 characters.
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// FieldError is one invalid field of the input, Field is its JSON name.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// trimCustomer trims the fields of customer that are set, before they are validated and stored.
+func trimCustomer(customer *repository.CustomerInfo) {
+	for _, value := range []**string{&customer.FirstName, &customer.LastName, &customer.PatronymicName, &customer.Phone, &customer.Email} {
+		if *value != nil {
+			trimmed := strings.TrimSpace(**value)
+			*value = &trimmed
+		}
+	}
+}
+
+// validateCustomer checks the fields of the trimmed customer that are set, so that it suits both
+// full writes and patches. Fields equal to the ones of current, the stored customer or nil on
+// create, are not checked: rows written before validation existed can be sent back unchanged.
+// It reports every invalid field at once.
+func validateCustomer(customer repository.CustomerInfo, current *repository.CustomerInfo) error {
+	if current == nil {
+		current = &repository.CustomerInfo{}
+	}
+	var fieldErrors []FieldError
+	check := func(field string, value, stored *string, validate func(string) string) {
+		if value == nil || (stored != nil && *stored == *value) {
+			return
+		}
+		if message := validate(*value); message != "" {
+			fieldErrors = append(fieldErrors, FieldError{Field: field, Message: message})
+		}
+	}
+
+	check("firstName", customer.FirstName, current.FirstName, validateName)
+	check("lastName", customer.LastName, current.LastName, validateName)
+	check("patronymicName", customer.PatronymicName, current.PatronymicName, validateName)
+	check("phone", customer.Phone, current.Phone, validatePhone)
+	check("email", customer.Email, current.Email, validateEmail)
+
+	if len(fieldErrors) > 0 {
+		return &Error{Code: CodeInvalidFields, Message: "invalid customer fields", Fields: fieldErrors}
+	}
+	return nil
+}
+
+// validateName accepts letters in any script, separated by spaces, hyphens, apostrophes or
+// dots, as in "Анна-Мария" or "O'Brien".
+func validateName(name string) string {
+	if name == "" {
+		return "cannot be empty"
+	}
+	if length := utf8.RuneCountInString(name); length > maxNameLength {
+		return fmt.Sprintf("must be at most %d characters long, got %d", maxNameLength, length)
+	}
+	for i, r := range name {
+		switch {
+		case unicode.IsLetter(r):
+		case i > 0 && unicode.Is(unicode.Mn, r):
+			// Combining accents follow a letter
+		case i > 0 && strings.ContainsRune(" -'’.", r):
+		default:
+			return fmt.Sprintf("must start with a letter and contain only letters, spaces, hyphens, apostrophes and dots, got %q", r)
+		}
+	}
+	return ""
+}
+
+func validatePhone(phone string) string {
+	if !e164.MatchString(phone) {
+		return "must be in E.164 format, e.g. +79991234567"
+	}
+	return ""
+}
+
+// validateEmail accepts a bare address, without a display name or angle brackets.
+func validateEmail(email string) string {
+	if length := utf8.RuneCountInString(email); length > maxEmailLength {
+		return fmt.Sprintf("must be at most %d characters long, got %d", maxEmailLength, length)
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Name != "" || address.Address != email {
+		return "must be a valid email address, e.g. client@example.com"
+	}
+	return ""
+}
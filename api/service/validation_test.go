@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vlegro/backend/api/repository"
+)
+
+func TestValidateCustomer(t *testing.T) {
+	tests := []struct {
+		name           string
+		customer       repository.CustomerInfo
+		expected       repository.CustomerInfo
+		expectedFields []string
+	}{
+		{
+			name: "valid customer is trimmed",
+			customer: repository.CustomerInfo{
+				FirstName:      strPtr("  Анна-Мария "),
+				LastName:       strPtr("O'Brien"),
+				PatronymicName: strPtr("Ивановна\t"),
+				Phone:          strPtr(" +79991234567"),
+				Email:          strPtr("anna.maria@example.com\n"),
+			},
+			expected: repository.CustomerInfo{
+				FirstName:      strPtr("Анна-Мария"),
+				LastName:       strPtr("O'Brien"),
+				PatronymicName: strPtr("Ивановна"),
+				Phone:          strPtr("+79991234567"),
+				Email:          strPtr("anna.maria@example.com"),
+			},
+		},
+		{
+			name:     "unset fields are not checked",
+			customer: repository.CustomerInfo{Phone: strPtr("+442071838750")},
+			expected: repository.CustomerInfo{Phone: strPtr("+442071838750")},
+		},
+		{
+			name: "every invalid field is reported",
+			customer: repository.CustomerInfo{
+				FirstName:      strPtr("   "),
+				LastName:       strPtr("Клиент1"),
+				PatronymicName: strPtr(strings.Repeat("я", maxNameLength+1)),
+				Phone:          strPtr("8 (999) 123-45-67"),
+				Email:          strPtr("Клиент <client@example.com>"),
+			},
+			expectedFields: []string{"firstName", "lastName", "patronymicName", "phone", "email"},
+		},
+		{
+			name:           "phone too long for E.164",
+			customer:       repository.CustomerInfo{Phone: strPtr("+1234567890123456")},
+			expectedFields: []string{"phone"},
+		},
+		{
+			name:           "email without domain",
+			customer:       repository.CustomerInfo{Email: strPtr("client@")},
+			expectedFields: []string{"email"},
+		},
+		{
+			name:           "email too long",
+			customer:       repository.CustomerInfo{Email: strPtr(strings.Repeat("a", maxEmailLength) + "@example.com")},
+			expectedFields: []string{"email"},
+		},
+		{
+			name:           "name starting with a separator",
+			customer:       repository.CustomerInfo{FirstName: strPtr("-Анна")},
+			expectedFields: []string{"firstName"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			customer := tt.customer
+			trimCustomer(&customer)
+			err := validateCustomer(customer, nil)
+
+			if tt.expectedFields == nil {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, customer)
+				return
+			}
+			var serviceErr *Error
+			require.ErrorAs(t, err, &serviceErr)
+			assert.Equal(t, CodeInvalidFields, serviceErr.Code)
+			var fields []string
+			for _, fieldErr := range serviceErr.Fields {
+				fields = append(fields, fieldErr.Field)
+				assert.NotEmpty(t, fieldErr.Message)
+			}
+			assert.Equal(t, tt.expectedFields, fields)
+		})
+	}
+}
+
+func TestCustomerService_Write_Validation(t *testing.T) {
+	mockRepo := new(MockCustomerRepository)
+	ctx := context.Background()
+	service := NewCustomerService(mockRepo)
+	invalid := repository.CustomerInfo{FirstName: strPtr("Клиент"), Email: strPtr("client")}
+
+	mockRepo.stored = map[int]repository.CustomerInfo{1: {Id: 1, FirstName: strPtr("Клиент")}}
+
+	// Invalid input is not written
+	_, err := service.Create(ctx, invalid)
+	assert.Equal(t, CodeInvalidFields, ErrorCode(err))
+	_, err = service.Update(ctx, 1, invalid)
+	assert.Equal(t, CodeInvalidFields, ErrorCode(err))
+	_, err = service.Patch(ctx, 1, invalid)
+	assert.Equal(t, CodeInvalidFields, ErrorCode(err))
+	mockRepo.AssertExpectations(t)
+
+	// The repository gets the trimmed values
+	mockRepo.On("Patch", 1, repository.CustomerInfo{Email: strPtr("client@example.com")}).
+		Return(repository.CustomerInfo{Id: 1, Email: strPtr("client@example.com")}, nil)
+	_, err = service.Patch(ctx, 1, repository.CustomerInfo{Email: strPtr(" client@example.com ")})
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCustomerService_Update_StoredValues(t *testing.T) {
+	mockRepo := new(MockCustomerRepository)
+	ctx := context.Background()
+	service := NewCustomerService(mockRepo)
+
+	// Rows written before validation existed, like the seeded ones, fail the current rules
+	stored := repository.CustomerInfo{
+		Id:             1,
+		FirstName:      strPtr("Клиент1"),
+		LastName:       strPtr("Клиентов1"),
+		PatronymicName: strPtr("Клиентович1"),
+		Phone:          strPtr("77777777777"),
+		Email:          strPtr("test1@test.ru"),
+	}
+	mockRepo.stored = map[int]repository.CustomerInfo{1: stored}
+	mockRepo.On("GetById", 1).Return(stored, nil).Once()
+	mockRepo.On("Update", stored).Return(stored, nil).Once()
+
+	// What GET returned can be sent back unchanged
+	fetched, err := service.GetById(ctx, 1)
+	require.NoError(t, err)
+	updated, err := service.Update(ctx, 1, fetched)
+	require.NoError(t, err)
+	assert.Equal(t, stored, updated)
+
+	// Changed fields are validated, the unchanged ones still are not
+	changed := stored
+	changed.Phone = strPtr("7777")
+	_, err = service.Update(ctx, 1, changed)
+	var serviceErr *Error
+	require.ErrorAs(t, err, &serviceErr)
+	assert.Equal(t, []FieldError{{Field: "phone", Message: "must be in E.164 format, e.g. +79991234567"}}, serviceErr.Fields)
+
+	mockRepo.On("Patch", 1, repository.CustomerInfo{Phone: strPtr("+77777777777"), FirstName: strPtr("Клиент1")}).
+		Return(stored, nil).Once()
+	_, err = service.Patch(ctx, 1, repository.CustomerInfo{Phone: strPtr("+77777777777"), FirstName: strPtr("Клиент1")})
+	require.NoError(t, err)
+
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,480 @@
+// Package config holds the settings shared by the API and the migrations binaries.
+//
+// Settings are read from, in precedence order: env variables, then the optional YAML or
+// JSON file named by -config or CONFIG_FILE, then command line flags, then the defaults.
+// So an env variable always wins and a flag only applies when neither the env nor the
+// file set the same setting. Everything is validated once at startup.
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	HTTP      HTTP      `yaml:"http" json:"http"`
+	DB        DB        `yaml:"db" json:"db"`
+	Log       Log       `yaml:"log" json:"log"`
+	Tracing   Tracing   `yaml:"tracing" json:"tracing"`
+	Auth      Auth      `yaml:"auth" json:"auth"`
+	RateLimit RateLimit `yaml:"rateLimit" json:"rateLimit"`
+	Customers Customers `yaml:"customers" json:"customers"`
+	Features  Features  `yaml:"features" json:"features"`
+}
+
+// HTTP configures the API server.
+type HTTP struct {
+	Port         int      `yaml:"port" json:"port"`
+	ReadTimeout  Duration `yaml:"readTimeout" json:"readTimeout"`
+	WriteTimeout Duration `yaml:"writeTimeout" json:"writeTimeout"`
+	IdleTimeout  Duration `yaml:"idleTimeout" json:"idleTimeout"`
+	// ShutdownGracePeriod bounds how long in-flight requests are drained on SIGTERM.
+	ShutdownGracePeriod Duration `yaml:"shutdownGracePeriod" json:"shutdownGracePeriod"`
+	// Timeouts are the per-endpoint deadlines, zero leaves an endpoint without one.
+	Timeouts Timeouts `yaml:"timeouts" json:"timeouts"`
+	// MaxBodyBytes caps the size of request bodies, larger ones are answered 413. Zero leaves
+	// them unlimited.
+	MaxBodyBytes int `yaml:"maxBodyBytes" json:"maxBodyBytes"`
+	// CompressionLevel is the gzip and deflate level of responses, 1 to 9, zero disables compression.
+	CompressionLevel int `yaml:"compressionLevel" json:"compressionLevel"`
+	// SecurityHeaders adds the headers that keep browsers from sniffing, framing or caching responses.
+	SecurityHeaders bool `yaml:"securityHeaders" json:"securityHeaders"`
+	CORS            CORS `yaml:"cors" json:"cors"`
+}
+
+// CORS configures cross-origin requests from browsers, they are not allowed while
+// AllowedOrigins is empty.
+type CORS struct {
+	// AllowedOrigins are exact origins such as https://admin.example.com, or * for any.
+	AllowedOrigins []string `yaml:"allowedOrigins" json:"allowedOrigins"`
+	AllowedMethods []string `yaml:"allowedMethods" json:"allowedMethods"`
+	// AllowedHeaders are the request headers scripts may set, besides the CORS-safelisted ones.
+	AllowedHeaders []string `yaml:"allowedHeaders" json:"allowedHeaders"`
+	// ExposedHeaders are the response headers scripts may read.
+	ExposedHeaders []string `yaml:"exposedHeaders" json:"exposedHeaders"`
+	// AllowCredentials lets browsers send cookies and HTTP authentication, it cannot be used with *.
+	AllowCredentials bool `yaml:"allowCredentials" json:"allowCredentials"`
+	// MaxAge is how long browsers may cache a preflight response.
+	MaxAge Duration `yaml:"maxAge" json:"maxAge"`
+}
+
+// Timeouts mirrors controller.Timeouts.
+type Timeouts struct {
+	GetByPrefix    Duration `yaml:"getByPrefix" json:"getByPrefix"`
+	DeleteByPrefix Duration `yaml:"deleteByPrefix" json:"deleteByPrefix"`
+	Restore        Duration `yaml:"restore" json:"restore"`
+	Read           Duration `yaml:"read" json:"read"`
+	Write          Duration `yaml:"write" json:"write"`
+	Audit          Duration `yaml:"audit" json:"audit"`
+}
+
+// DB configures the connection pool, zero sizes and durations keep the database/sql defaults.
+type DB struct {
+	URL             string   `yaml:"url" json:"url"`
+	MaxOpenConns    int      `yaml:"maxOpenConns" json:"maxOpenConns"`
+	MaxIdleConns    int      `yaml:"maxIdleConns" json:"maxIdleConns"`
+	ConnMaxLifetime Duration `yaml:"connMaxLifetime" json:"connMaxLifetime"`
+	ConnMaxIdleTime Duration `yaml:"connMaxIdleTime" json:"connMaxIdleTime"`
+	// ConnectTimeout is how long startup waits for the database, zero tries only once.
+	ConnectTimeout Duration `yaml:"connectTimeout" json:"connectTimeout"`
+	// ConnectBackoff is the first pause between connection attempts, it doubles on each retry.
+	ConnectBackoff Duration `yaml:"connectBackoff" json:"connectBackoff"`
+}
+
+type Log struct {
+	// Level is one of debug, info, warn and error.
+	Level string `yaml:"level" json:"level"`
+}
+
+// Tracing configures OpenTelemetry tracing.
+type Tracing struct {
+	// Exporter is one of none, stdout and otlp. With none, incoming trace context is still honored.
+	Exporter string `yaml:"exporter" json:"exporter"`
+	// Endpoint is the OTLP/HTTP collector URL, e.g. http://localhost:4318. When empty the
+	// standard OTEL_EXPORTER_OTLP_* env variables apply.
+	Endpoint    string `yaml:"endpoint" json:"endpoint"`
+	ServiceName string `yaml:"serviceName" json:"serviceName"`
+	// SampleRatio is the share of new traces recorded, traces started upstream follow the caller's decision.
+	SampleRatio float64 `yaml:"sampleRatio" json:"sampleRatio"`
+}
+
+// Auth configures how callers of the customer API authenticate.
+type Auth struct {
+	// Required rejects requests without credentials, otherwise they are served as anonymous.
+	// Invalid credentials are always rejected.
+	Required bool `yaml:"required" json:"required"`
+	// APIKeys are accepted in the X-API-Key header, they can only be set in the config file.
+	APIKeys []APIKey `yaml:"apiKeys" json:"apiKeys"`
+	JWT     JWT      `yaml:"jwt" json:"jwt"`
+	// Roles are referred to by API keys and by the roles claim of bearer tokens.
+	Roles map[string]Role `yaml:"roles" json:"roles"`
+	// AnonymousRoles are granted to requests without credentials while authentication is not
+	// required. There are none by default, so anonymous callers may do nothing; opening the API
+	// to them, e.g. with [reader] or [admin], is up to the operator.
+	AnonymousRoles []string `yaml:"anonymousRoles" json:"anonymousRoles"`
+}
+
+// Role grants permissions: customers:read, customers:write, customers:delete and audit:read.
+type Role struct {
+	Permissions []string `yaml:"permissions" json:"permissions"`
+	// DeletePrefixes restricts customers:delete to customers matching one of these prefixes.
+	DeletePrefixes []string `yaml:"deletePrefixes" json:"deletePrefixes"`
+}
+
+// APIKey is a static key, only its hash is configured.
+type APIKey struct {
+	// Name identifies the caller in the audit log and the access log.
+	Name string `yaml:"name" json:"name"`
+	// SHA256 is the hex encoded SHA-256 of the key, e.g. printf %s "$KEY" | sha256sum.
+	SHA256 string   `yaml:"sha256" json:"sha256"`
+	Roles  []string `yaml:"roles" json:"roles"`
+}
+
+// JWT configures the verification of bearer tokens, at least one of Secret and JWKSFile
+// enables them.
+type JWT struct {
+	// Secret verifies HS256, HS384 and HS512 tokens.
+	Secret string `yaml:"secret" json:"secret"`
+	// JWKSFile is a local JSON Web Key Set verifying RS*, PS*, ES* and EdDSA tokens by kid.
+	JWKSFile string `yaml:"jwksFile" json:"jwksFile"`
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string `yaml:"issuer" json:"issuer"`
+	Audience string `yaml:"audience" json:"audience"`
+}
+
+// RateLimit configures the per-client request limits of the customer API.
+type RateLimit struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// TrustForwardedFor takes the client address from X-Forwarded-For or X-Real-IP. Only enable
+	// it behind a proxy that sets them, clients could pick their address otherwise.
+	TrustForwardedFor bool `yaml:"trustForwardedFor" json:"trustForwardedFor"`
+	// Limits can only be set in the config file.
+	Limits Limits `yaml:"limits" json:"limits"`
+}
+
+// Limits mirrors controller.Limits.
+type Limits struct {
+	GetByPrefix    Limit `yaml:"getByPrefix" json:"getByPrefix"`
+	DeleteByPrefix Limit `yaml:"deleteByPrefix" json:"deleteByPrefix"`
+	Restore        Limit `yaml:"restore" json:"restore"`
+	Read           Limit `yaml:"read" json:"read"`
+	Write          Limit `yaml:"write" json:"write"`
+	DeleteById     Limit `yaml:"deleteById" json:"deleteById"`
+	Audit          Limit `yaml:"audit" json:"audit"`
+	// Authentication limits the failed authentications of every client address.
+	Authentication Limit `yaml:"authentication" json:"authentication"`
+}
+
+// Limit allows Rate requests per second with bursts of up to Burst, a zero Rate is unlimited.
+type Limit struct {
+	Rate  float64 `yaml:"rate" json:"rate"`
+	Burst int     `yaml:"burst" json:"burst"`
+}
+
+// Customers configures the customer service.
+type Customers struct {
+	// MaxDeleteCount is the number of customers a bulk delete may remove without confirmation,
+	// zero disables the check.
+	MaxDeleteCount int `yaml:"maxDeleteCount" json:"maxDeleteCount"`
+	// DeleteConfirmationSecret signs confirmation tokens, a random one is generated when empty.
+	DeleteConfirmationSecret string `yaml:"deleteConfirmationSecret" json:"deleteConfirmationSecret"`
+	DefaultPageSize          int    `yaml:"defaultPageSize" json:"defaultPageSize"`
+	MaxPageSize              int    `yaml:"maxPageSize" json:"maxPageSize"`
+}
+
+// Features switches optional parts of the API on and off.
+type Features struct {
+	// PatternSearch allows the pattern query parameter, i.e. LIKE wildcards in prefixes.
+	PatternSearch bool `yaml:"patternSearch" json:"patternSearch"`
+	// Restore mounts POST /customers/restore.
+	Restore bool `yaml:"restore" json:"restore"`
+}
+
+const (
+	// minSecretLength keeps confirmation tokens from being signed with a guessable secret.
+	minSecretLength = 16
+	// minJWTSecretLength is the HS256 key size recommended by RFC 7518.
+	minJWTSecretLength = 32
+)
+
+// Default returns the settings used when nothing overrides them, DB.URL has no default.
+func Default() Config {
+	return Config{
+		HTTP: HTTP{
+			Port:                3322,
+			ReadTimeout:         Duration(10 * time.Second),
+			WriteTimeout:        Duration(60 * time.Second),
+			IdleTimeout:         Duration(120 * time.Second),
+			ShutdownGracePeriod: Duration(30 * time.Second),
+			Timeouts: Timeouts{
+				GetByPrefix:    Duration(10 * time.Second),
+				DeleteByPrefix: Duration(30 * time.Second),
+				Restore:        Duration(30 * time.Second),
+				Read:           Duration(5 * time.Second),
+				Write:          Duration(5 * time.Second),
+				Audit:          Duration(10 * time.Second),
+			},
+			MaxBodyBytes:     1 << 20,
+			CompressionLevel: 5,
+			SecurityHeaders:  true,
+			CORS: CORS{
+				AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+				AllowedHeaders: []string{"Authorization", "Content-Type", "X-API-Key", "X-Request-ID"},
+				ExposedHeaders: []string{"Location", "X-Request-ID", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+				MaxAge:         Duration(10 * time.Minute),
+			},
+		},
+		DB: DB{
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: Duration(30 * time.Minute),
+			ConnMaxIdleTime: Duration(5 * time.Minute),
+			ConnectTimeout:  Duration(time.Minute),
+			ConnectBackoff:  Duration(500 * time.Millisecond),
+		},
+		Log: Log{Level: "info"},
+		Tracing: Tracing{
+			Exporter:    "none",
+			ServiceName: "backend",
+			SampleRatio: 1,
+		},
+		Auth: Auth{
+			Roles: map[string]Role{
+				"reader": {Permissions: []string{"customers:read"}},
+				"writer": {Permissions: []string{"customers:read", "customers:write"}},
+				"admin":  {Permissions: []string{"customers:read", "customers:write", "customers:delete", "audit:read"}},
+			},
+		},
+		RateLimit: RateLimit{
+			Enabled: true,
+			Limits: Limits{
+				GetByPrefix:    Limit{Rate: 10, Burst: 20},
+				DeleteByPrefix: Limit{Rate: 0.2, Burst: 3},
+				Restore:        Limit{Rate: 0.2, Burst: 3},
+				Read:           Limit{Rate: 50, Burst: 100},
+				Write:          Limit{Rate: 20, Burst: 40},
+				DeleteById:     Limit{Rate: 2, Burst: 10},
+				Audit:          Limit{Rate: 2, Burst: 10},
+				Authentication: Limit{Rate: 0.1, Burst: 10},
+			},
+		},
+		Customers: Customers{
+			MaxDeleteCount:  100,
+			DefaultPageSize: 50,
+			MaxPageSize:     100,
+		},
+		Features: Features{
+			PatternSearch: true,
+			Restore:       true,
+		},
+	}
+}
+
+// Validate reports every invalid setting at once.
+func (c Config) Validate() error {
+	var errs []error
+	fail := func(setting, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", setting, fmt.Sprintf(format, args...)))
+	}
+
+	if c.HTTP.Port < 1 || c.HTTP.Port > 65535 {
+		fail("http.port", "must be between 1 and 65535, got %d", c.HTTP.Port)
+	}
+	type namedDuration struct {
+		setting string
+		value   Duration
+	}
+	endpointTimeouts := []namedDuration{
+		{"http.timeouts.getByPrefix", c.HTTP.Timeouts.GetByPrefix},
+		{"http.timeouts.deleteByPrefix", c.HTTP.Timeouts.DeleteByPrefix},
+		{"http.timeouts.restore", c.HTTP.Timeouts.Restore},
+		{"http.timeouts.read", c.HTTP.Timeouts.Read},
+		{"http.timeouts.write", c.HTTP.Timeouts.Write},
+		{"http.timeouts.audit", c.HTTP.Timeouts.Audit},
+	}
+	durations := append([]namedDuration{
+		{"http.readTimeout", c.HTTP.ReadTimeout},
+		{"http.writeTimeout", c.HTTP.WriteTimeout},
+		{"http.idleTimeout", c.HTTP.IdleTimeout},
+		{"http.shutdownGracePeriod", c.HTTP.ShutdownGracePeriod},
+		{"db.connMaxLifetime", c.DB.ConnMaxLifetime},
+		{"db.connMaxIdleTime", c.DB.ConnMaxIdleTime},
+		{"db.connectTimeout", c.DB.ConnectTimeout},
+	}, endpointTimeouts...)
+	for _, d := range durations {
+		if d.value < 0 {
+			fail(d.setting, "cannot be negative, got %s", d.value)
+		}
+	}
+	// The server would cut the connection before a slow endpoint could answer 504
+	if c.HTTP.WriteTimeout > 0 {
+		for _, d := range endpointTimeouts {
+			if d.value == 0 || d.value >= c.HTTP.WriteTimeout {
+				fail(d.setting, "must be set and shorter than http.writeTimeout (%s), got %s", c.HTTP.WriteTimeout, d.value)
+			}
+		}
+	}
+
+	if c.HTTP.MaxBodyBytes < 0 {
+		fail("http.maxBodyBytes", "cannot be negative, got %d", c.HTTP.MaxBodyBytes)
+	}
+	if c.HTTP.CompressionLevel < 0 || c.HTTP.CompressionLevel > 9 {
+		fail("http.compressionLevel", "must be between 0 and 9, got %d", c.HTTP.CompressionLevel)
+	}
+	for i, origin := range c.HTTP.CORS.AllowedOrigins {
+		setting := fmt.Sprintf("http.cors.allowedOrigins[%d]", i)
+		if origin == "*" {
+			if c.HTTP.CORS.AllowCredentials {
+				fail(setting, "cannot be * with http.cors.allowCredentials")
+			}
+			continue
+		}
+		// Browsers send the origin as scheme://host[:port], nothing else would ever match
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.User != nil {
+			fail(setting, "must be * or scheme://host[:port], got %q", origin)
+		}
+	}
+	if c.HTTP.CORS.MaxAge < 0 {
+		fail("http.cors.maxAge", "cannot be negative, got %s", c.HTTP.CORS.MaxAge)
+	}
+
+	if c.DB.URL == "" {
+		fail("db.url", "is required")
+	}
+	if c.DB.ConnectTimeout > 0 && c.DB.ConnectBackoff <= 0 {
+		fail("db.connectBackoff", "must be positive when db.connectTimeout is set, got %s", c.DB.ConnectBackoff)
+	}
+	if c.DB.MaxOpenConns < 0 {
+		fail("db.maxOpenConns", "cannot be negative, got %d", c.DB.MaxOpenConns)
+	}
+	if c.DB.MaxIdleConns < 0 {
+		fail("db.maxIdleConns", "cannot be negative, got %d", c.DB.MaxIdleConns)
+	}
+	if c.DB.MaxOpenConns > 0 && c.DB.MaxIdleConns > c.DB.MaxOpenConns {
+		fail("db.maxIdleConns", "cannot exceed db.maxOpenConns (%d), got %d", c.DB.MaxOpenConns, c.DB.MaxIdleConns)
+	}
+
+	if _, err := c.Log.SlogLevel(); err != nil {
+		fail("log.level", "must be one of debug, info, warn and error, got %q", c.Log.Level)
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		fail("tracing.exporter", "must be one of none, stdout and otlp, got %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		fail("tracing.sampleRatio", "must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+	}
+	if c.Tracing.ServiceName == "" {
+		fail("tracing.serviceName", "is required")
+	}
+
+	names := map[string]bool{}
+	for i, key := range c.Auth.APIKeys {
+		setting := fmt.Sprintf("auth.apiKeys[%d]", i)
+		if key.Name == "" {
+			fail(setting+".name", "is required")
+		} else if names[key.Name] {
+			fail(setting+".name", "duplicate name %q", key.Name)
+		}
+		names[key.Name] = true
+		if hash, err := hex.DecodeString(key.SHA256); err != nil || len(hash) != sha256.Size {
+			fail(setting+".sha256", "must be %d hex characters", 2*sha256.Size)
+		}
+		for _, role := range key.Roles {
+			if _, ok := c.Auth.Roles[role]; !ok {
+				fail(setting+".roles", "unknown role %q", role)
+			}
+		}
+	}
+	for _, role := range c.Auth.AnonymousRoles {
+		if _, ok := c.Auth.Roles[role]; !ok {
+			fail("auth.anonymousRoles", "unknown role %q", role)
+		}
+	}
+	if secret := c.Auth.JWT.Secret; secret != "" && len(secret) < minJWTSecretLength {
+		fail("auth.jwt.secret", "must be at least %d bytes long", minJWTSecretLength)
+	}
+	if c.Auth.Required && len(c.Auth.APIKeys) == 0 && c.Auth.JWT.Secret == "" && c.Auth.JWT.JWKSFile == "" {
+		fail("auth.required", "needs API keys, auth.jwt.secret or auth.jwt.jwksFile")
+	}
+
+	type namedLimit struct {
+		setting string
+		value   Limit
+	}
+	limits := []namedLimit{
+		{"rateLimit.limits.getByPrefix", c.RateLimit.Limits.GetByPrefix},
+		{"rateLimit.limits.deleteByPrefix", c.RateLimit.Limits.DeleteByPrefix},
+		{"rateLimit.limits.restore", c.RateLimit.Limits.Restore},
+		{"rateLimit.limits.read", c.RateLimit.Limits.Read},
+		{"rateLimit.limits.write", c.RateLimit.Limits.Write},
+		{"rateLimit.limits.deleteById", c.RateLimit.Limits.DeleteById},
+		{"rateLimit.limits.audit", c.RateLimit.Limits.Audit},
+		{"rateLimit.limits.authentication", c.RateLimit.Limits.Authentication},
+	}
+	for _, l := range limits {
+		if l.value.Rate < 0 {
+			fail(l.setting+".rate", "cannot be negative, got %g", l.value.Rate)
+		}
+		if l.value.Rate > 0 && l.value.Burst < 1 {
+			fail(l.setting+".burst", "must be positive when rate is set, got %d", l.value.Burst)
+		}
+	}
+
+	if c.Customers.MaxDeleteCount < 0 {
+		fail("customers.maxDeleteCount", "cannot be negative, got %d", c.Customers.MaxDeleteCount)
+	}
+	if secret := c.Customers.DeleteConfirmationSecret; secret != "" && len(secret) < minSecretLength {
+		fail("customers.deleteConfirmationSecret", "must be at least %d bytes long", minSecretLength)
+	}
+	if c.Customers.DefaultPageSize < 1 {
+		fail("customers.defaultPageSize", "must be positive, got %d", c.Customers.DefaultPageSize)
+	}
+	if c.Customers.MaxPageSize < c.Customers.DefaultPageSize {
+		fail("customers.maxPageSize", "cannot be less than customers.defaultPageSize (%d), got %d",
+			c.Customers.DefaultPageSize, c.Customers.MaxPageSize)
+	}
+
+	return errors.Join(errs...)
+}
+
+// SlogLevel parses Level.
+func (l Log) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(strings.TrimSpace(l.Level)))
+	return level, err
+}
+
+// Duration is a time.Duration written as "30s" or "1m30s" in files, flags and env variables.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Set implements flag.Value.
+func (d *Duration) Set(value string) error {
+	parsed, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	return d.Set(string(text))
+}
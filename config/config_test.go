@@ -0,0 +1,206 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func env(values map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, exists := values[name]
+		return value, exists
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	c, args, err := load([]string{"api", "migrate"}, env(map[string]string{"DB_CONNECTION_URL": "postgres://db"}))
+
+	require.NoError(t, err)
+	expected := Default()
+	expected.DB.URL = "postgres://db"
+	assert.Equal(t, expected, c)
+	assert.Equal(t, []string{"migrate"}, args)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, "backend.yaml", `
+http:
+  port: 8081
+  timeouts:
+    read: 2s
+db:
+  url: postgres://file
+customers:
+  maxDeleteCount: 20
+`)
+
+	c, _, err := load(
+		[]string{"api", "-config", path, "-port", "8080", "-max-delete-count", "10", "-log-level", "debug", "-feature-restore=false"},
+		env(map[string]string{"MAX_DELETE_COUNT": "30", "READ_TIMEOUT": "3s"}),
+	)
+
+	require.NoError(t, err)
+	// The env beats the file, the file beats the flags, flags beat the defaults
+	assert.Equal(t, 30, c.Customers.MaxDeleteCount)
+	assert.Equal(t, Duration(3*time.Second), c.HTTP.Timeouts.Read)
+	assert.Equal(t, 8081, c.HTTP.Port)
+	assert.Equal(t, "postgres://file", c.DB.URL)
+	assert.Equal(t, "debug", c.Log.Level)
+	assert.False(t, c.Features.Restore)
+	assert.Equal(t, Default().HTTP.Timeouts.Write, c.HTTP.Timeouts.Write)
+}
+
+func TestLoad_JSONFile(t *testing.T) {
+	path := writeFile(t, "backend.json", `{"db": {"url": "postgres://json", "maxOpenConns": 5, "maxIdleConns": 5}}`)
+
+	c, _, err := load([]string{"api"}, env(map[string]string{"CONFIG_FILE": path}))
+
+	require.NoError(t, err)
+	assert.Equal(t, "postgres://json", c.DB.URL)
+	assert.Equal(t, 5, c.DB.MaxOpenConns)
+}
+
+func TestLoad_Roles(t *testing.T) {
+	path := writeFile(t, "backend.yaml", `
+db:
+  url: postgres://file
+auth:
+  roles:
+    cleanup:
+      permissions: [customers:read, customers:delete]
+      deletePrefixes: [Тест]
+  apiKeys:
+    - name: cleanup-job
+      sha256: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+      roles: [cleanup]
+`)
+
+	c, _, err := load([]string{"api", "-config", path}, env(map[string]string{"AUTH_ANONYMOUS_ROLES": "reader, writer"}))
+
+	require.NoError(t, err)
+	// Roles of the file are added to the default ones
+	assert.Equal(t, Role{Permissions: []string{"customers:read", "customers:delete"}, DeletePrefixes: []string{"Тест"}}, c.Auth.Roles["cleanup"])
+	assert.Equal(t, Default().Auth.Roles["reader"], c.Auth.Roles["reader"])
+	assert.Equal(t, []string{"reader", "writer"}, c.Auth.AnonymousRoles)
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		file     string
+		contains []string
+	}{
+		{
+			name:     "missing url",
+			contains: []string{"db.url: is required"},
+		},
+		{
+			name: "every invalid setting is reported",
+			env: map[string]string{
+				"DB_CONNECTION_URL": "postgres://db",
+				"PORT":              "70000",
+				"LOG_LEVEL":         "verbose",
+				"DB_MAX_IDLE_CONNS": "50",
+				"MAX_PAGE_SIZE":     "10",
+			},
+			contains: []string{
+				"http.port: must be between 1 and 65535, got 70000",
+				`log.level: must be one of debug, info, warn and error, got "verbose"`,
+				"db.maxIdleConns: cannot exceed db.maxOpenConns (25), got 50",
+				"customers.maxPageSize: cannot be less than customers.defaultPageSize (50), got 10",
+			},
+		},
+		{
+			name:     "endpoint timeout above the write timeout",
+			env:      map[string]string{"DB_CONNECTION_URL": "postgres://db", "DELETE_BY_PREFIX_TIMEOUT": "2m"},
+			contains: []string{"http.timeouts.deleteByPrefix: must be set and shorter than http.writeTimeout (1m0s), got 2m0s"},
+		},
+		{
+			name: "invalid auth",
+			file: "db:\n  url: postgres://db\nauth:\n  apiKeys:\n    - name: ops\n      sha256: abc\n    - name: ops\n      sha256: abc\n",
+			env:  map[string]string{"AUTH_JWT_SECRET": "short"},
+			contains: []string{
+				"auth.apiKeys[0].sha256: must be 64 hex characters",
+				`auth.apiKeys[1].name: duplicate name "ops"`,
+				"auth.jwt.secret: must be at least 32 bytes long",
+			},
+		},
+		{
+			name: "unknown roles",
+			file: "db:\n  url: postgres://db\nauth:\n  apiKeys:\n    - name: ops\n      sha256: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08\n      roles: [root]\n",
+			env:  map[string]string{"AUTH_ANONYMOUS_ROLES": "guest"},
+			contains: []string{
+				`auth.apiKeys[0].roles: unknown role "root"`,
+				`auth.anonymousRoles: unknown role "guest"`,
+			},
+		},
+		{
+			name:     "auth required without credentials",
+			env:      map[string]string{"DB_CONNECTION_URL": "postgres://db", "AUTH_REQUIRED": "true"},
+			contains: []string{"auth.required: needs API keys, auth.jwt.secret or auth.jwt.jwksFile"},
+		},
+		{
+			name: "invalid middleware settings",
+			file: "db:\n  url: postgres://db\nhttp:\n  maxBodyBytes: -1\n  compressionLevel: 10\n  cors:\n    allowedOrigins: ['*', admin.example.com, https://admin.example.com/]\n    allowCredentials: true\n",
+			contains: []string{
+				"http.maxBodyBytes: cannot be negative, got -1",
+				"http.compressionLevel: must be between 0 and 9, got 10",
+				"http.cors.allowedOrigins[0]: cannot be * with http.cors.allowCredentials",
+				`http.cors.allowedOrigins[1]: must be * or scheme://host[:port], got "admin.example.com"`,
+				`http.cors.allowedOrigins[2]: must be * or scheme://host[:port], got "https://admin.example.com/"`,
+			},
+		},
+		{
+			name: "invalid rate limits",
+			file: "db:\n  url: postgres://db\nrateLimit:\n  limits:\n    read:\n      rate: -1\n    write:\n      rate: 5\n      burst: 0\n",
+			contains: []string{
+				"rateLimit.limits.read.rate: cannot be negative, got -1",
+				"rateLimit.limits.write.burst: must be positive when rate is set, got 0",
+			},
+		},
+		{
+			name:     "malformed env",
+			env:      map[string]string{"PORT": "http", "HTTP_IDLE_TIMEOUT": "5"},
+			contains: []string{`PORT: invalid value "http": not an integer`, `HTTP_IDLE_TIMEOUT: invalid value "5"`},
+		},
+		{
+			name:     "malformed flag",
+			args:     []string{"-shutdown-grace-period", "soon"},
+			contains: []string{"-shutdown-grace-period"},
+		},
+		{
+			name:     "unknown file setting",
+			file:     "db:\n  host: localhost\n",
+			contains: []string{"field host not found"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"api"}, tt.args...)
+			if tt.file != "" {
+				args = append(args, "-config", writeFile(t, "backend.yaml", tt.file))
+			}
+
+			_, _, err := load(args, env(tt.env))
+
+			require.Error(t, err)
+			for _, message := range tt.contains {
+				assert.Contains(t, err.Error(), message)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// setting binds one field of Config to its flag and env variable.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	value flag.Value
+}
+
+// settings lists every setting of c, flags and env variables write straight into c.
+func settings(c *Config) []setting {
+	return []setting{
+		{"port", "PORT", "HTTP port", intValue{&c.HTTP.Port}},
+		{"http-read-timeout", "HTTP_READ_TIMEOUT", "maximum duration for reading a request", &c.HTTP.ReadTimeout},
+		{"http-write-timeout", "HTTP_WRITE_TIMEOUT", "maximum duration for writing a response", &c.HTTP.WriteTimeout},
+		{"http-idle-timeout", "HTTP_IDLE_TIMEOUT", "maximum keep-alive idle time", &c.HTTP.IdleTimeout},
+		{"shutdown-grace-period", "SHUTDOWN_GRACE_PERIOD", "time given to in-flight requests on shutdown", &c.HTTP.ShutdownGracePeriod},
+		{"get-by-prefix-timeout", "GET_BY_PREFIX_TIMEOUT", "deadline of GET /customers", &c.HTTP.Timeouts.GetByPrefix},
+		{"delete-by-prefix-timeout", "DELETE_BY_PREFIX_TIMEOUT", "deadline of DELETE /customers", &c.HTTP.Timeouts.DeleteByPrefix},
+		{"restore-timeout", "RESTORE_TIMEOUT", "deadline of POST /customers/restore", &c.HTTP.Timeouts.Restore},
+		{"read-timeout", "READ_TIMEOUT", "deadline of GET /customers/{id}", &c.HTTP.Timeouts.Read},
+		{"write-timeout", "WRITE_TIMEOUT", "deadline of single customer writes", &c.HTTP.Timeouts.Write},
+		{"audit-timeout", "AUDIT_TIMEOUT", "deadline of GET /audit", &c.HTTP.Timeouts.Audit},
+		{"max-body-bytes", "MAX_BODY_BYTES", "largest request body, 0 is unlimited", intValue{&c.HTTP.MaxBodyBytes}},
+		{"compression-level", "COMPRESSION_LEVEL", "gzip and deflate level of responses, 0 disables compression", intValue{&c.HTTP.CompressionLevel}},
+		{"security-headers", "SECURITY_HEADERS", "add security headers to every response", boolValue{&c.HTTP.SecurityHeaders}},
+		{"cors-allowed-origins", "CORS_ALLOWED_ORIGINS", "comma separated origins allowed to call the API from browsers", listValue{&c.HTTP.CORS.AllowedOrigins}},
+		{"cors-allowed-methods", "CORS_ALLOWED_METHODS", "comma separated methods allowed from other origins", listValue{&c.HTTP.CORS.AllowedMethods}},
+		{"cors-allowed-headers", "CORS_ALLOWED_HEADERS", "comma separated request headers allowed from other origins", listValue{&c.HTTP.CORS.AllowedHeaders}},
+		{"cors-allow-credentials", "CORS_ALLOW_CREDENTIALS", "allow cookies and HTTP authentication from other origins", boolValue{&c.HTTP.CORS.AllowCredentials}},
+		{"db-url", "DB_CONNECTION_URL", "postgres connection URL", stringValue{&c.DB.URL}},
+		{"db-max-open-conns", "DB_MAX_OPEN_CONNS", "maximum number of open connections, 0 is unlimited", intValue{&c.DB.MaxOpenConns}},
+		{"db-max-idle-conns", "DB_MAX_IDLE_CONNS", "maximum number of idle connections", intValue{&c.DB.MaxIdleConns}},
+		{"db-conn-max-lifetime", "DB_CONN_MAX_LIFETIME", "maximum lifetime of a connection, 0 is unlimited", &c.DB.ConnMaxLifetime},
+		{"db-conn-max-idle-time", "DB_CONN_MAX_IDLE_TIME", "maximum idle time of a connection, 0 is unlimited", &c.DB.ConnMaxIdleTime},
+		{"db-connect-timeout", "DB_CONNECT_TIMEOUT", "how long startup waits for the database, 0 tries once", &c.DB.ConnectTimeout},
+		{"db-connect-backoff", "DB_CONNECT_BACKOFF", "first pause between connection attempts", &c.DB.ConnectBackoff},
+		{"log-level", "LOG_LEVEL", "debug, info, warn or error", stringValue{&c.Log.Level}},
+		{"tracing-exporter", "TRACING_EXPORTER", "none, stdout or otlp", stringValue{&c.Tracing.Exporter}},
+		{"tracing-endpoint", "TRACING_ENDPOINT", "OTLP/HTTP collector URL", stringValue{&c.Tracing.Endpoint}},
+		{"tracing-service-name", "TRACING_SERVICE_NAME", "service.name of the spans", stringValue{&c.Tracing.ServiceName}},
+		{"tracing-sample-ratio", "TRACING_SAMPLE_RATIO", "share of new traces recorded, 0 to 1", floatValue{&c.Tracing.SampleRatio}},
+		{"auth-required", "AUTH_REQUIRED", "reject requests without credentials", boolValue{&c.Auth.Required}},
+		{"auth-anonymous-roles", "AUTH_ANONYMOUS_ROLES", "comma separated roles of requests without credentials", listValue{&c.Auth.AnonymousRoles}},
+		{"auth-jwt-secret", "AUTH_JWT_SECRET", "HMAC secret verifying bearer tokens", stringValue{&c.Auth.JWT.Secret}},
+		{"auth-jwt-jwks-file", "AUTH_JWT_JWKS_FILE", "JSON Web Key Set file verifying bearer tokens", stringValue{&c.Auth.JWT.JWKSFile}},
+		{"auth-jwt-issuer", "AUTH_JWT_ISSUER", "required iss claim of bearer tokens", stringValue{&c.Auth.JWT.Issuer}},
+		{"auth-jwt-audience", "AUTH_JWT_AUDIENCE", "required aud claim of bearer tokens", stringValue{&c.Auth.JWT.Audience}},
+		{"rate-limit", "RATE_LIMIT_ENABLED", "limit the request rate of every client", boolValue{&c.RateLimit.Enabled}},
+		{"rate-limit-trust-forwarded-for", "RATE_LIMIT_TRUST_FORWARDED_FOR", "take client addresses from X-Forwarded-For", boolValue{&c.RateLimit.TrustForwardedFor}},
+		{"max-delete-count", "MAX_DELETE_COUNT", "bulk delete size that requires confirmation, 0 disables it", intValue{&c.Customers.MaxDeleteCount}},
+		{"delete-confirmation-secret", "DELETE_CONFIRMATION_SECRET", "secret signing delete confirmation tokens", stringValue{&c.Customers.DeleteConfirmationSecret}},
+		{"default-page-size", "DEFAULT_PAGE_SIZE", "page size when the limit parameter is missing", intValue{&c.Customers.DefaultPageSize}},
+		{"max-page-size", "MAX_PAGE_SIZE", "largest page size", intValue{&c.Customers.MaxPageSize}},
+		{"feature-pattern-search", "FEATURE_PATTERN_SEARCH", "allow LIKE wildcards in prefixes", boolValue{&c.Features.PatternSearch}},
+		{"feature-restore", "FEATURE_RESTORE", "mount POST /customers/restore", boolValue{&c.Features.Restore}},
+	}
+}
+
+// Load reads the configuration of the binary started with args, args[0] is the program name.
+// It returns the arguments left after the flags.
+func Load(args []string) (Config, []string, error) {
+	return load(args, os.LookupEnv)
+}
+
+func load(args []string, lookupEnv func(string) (string, bool)) (Config, []string, error) {
+	c := Default()
+	all := settings(&c)
+
+	fs := flag.NewFlagSet(filepath.Base(args[0]), flag.ContinueOnError)
+	configFile := fs.String("config", "", "YAML or JSON config file, also CONFIG_FILE")
+	for _, s := range all {
+		fs.Var(s.value, s.flag, fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return Config{}, nil, err
+	}
+
+	// The file overrides the flags, the env overrides both
+	if path, exists := lookupEnv("CONFIG_FILE"); exists {
+		*configFile = path
+	}
+	if *configFile != "" {
+		if err := readFile(*configFile, &c); err != nil {
+			return Config{}, nil, err
+		}
+	}
+
+	var errs []error
+	for _, s := range all {
+		value, exists := lookupEnv(s.env)
+		if !exists {
+			continue
+		}
+		if err := s.value.Set(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid value %q: %w", s.env, value, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return Config{}, nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return Config{}, nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return c, fs.Args(), nil
+}
+
+// readFile decodes a YAML or JSON file over c, settings missing from the file are kept.
+func readFile(path string, c *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(c)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(c)
+		// An empty file sets nothing
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	default:
+		return fmt.Errorf("config file %s: unsupported extension, use .yaml, .yml or .json", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// stringValue, intValue, floatValue, boolValue and listValue adapt plain fields to flag.Value.
+type stringValue struct{ p *string }
+
+func (v stringValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return *v.p
+}
+
+func (v stringValue) Set(value string) error {
+	*v.p = value
+	return nil
+}
+
+type intValue struct{ p *int }
+
+func (v intValue) String() string {
+	if v.p == nil {
+		return "0"
+	}
+	return strconv.Itoa(*v.p)
+}
+
+func (v intValue) Set(value string) error {
+	parsed, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return errors.New("not an integer")
+	}
+	*v.p = parsed
+	return nil
+}
+
+type floatValue struct{ p *float64 }
+
+func (v floatValue) String() string {
+	if v.p == nil {
+		return "0"
+	}
+	return strconv.FormatFloat(*v.p, 'g', -1, 64)
+}
+
+func (v floatValue) Set(value string) error {
+	parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return errors.New("not a number")
+	}
+	*v.p = parsed
+	return nil
+}
+
+type boolValue struct{ p *bool }
+
+func (v boolValue) String() string {
+	if v.p == nil {
+		return "false"
+	}
+	return strconv.FormatBool(*v.p)
+}
+
+func (v boolValue) Set(value string) error {
+	parsed, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return errors.New("not a boolean")
+	}
+	*v.p = parsed
+	return nil
+}
+
+// IsBoolFlag lets -feature-restore stand for -feature-restore=true.
+func (v boolValue) IsBoolFlag() bool {
+	return true
+}
+
+// listValue reads a comma separated list, an empty value is an empty list.
+type listValue struct{ p *[]string }
+
+func (v listValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strings.Join(*v.p, ",")
+}
+
+func (v listValue) Set(value string) error {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*v.p = items
+	return nil
+}
@@ -0,0 +1,83 @@
+// Package database opens the Postgres connection pool shared by the API and the migrations.
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/vlegro/backend/config"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxBackoff caps the pause between connection attempts.
+	maxBackoff = 10 * time.Second
+	// pingTimeout bounds a single connection attempt.
+	pingTimeout = 5 * time.Second
+)
+
+// Open connects to the database configured by cfg and applies the pool settings. Until
+// cfg.ConnectTimeout runs out, unreachable databases are retried with a doubling backoff,
+// so the binaries can start before Postgres does.
+func Open(ctx context.Context, cfg config.DB) (*gorm.DB, error) {
+	if cfg.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.ConnectTimeout))
+		defer cancel()
+	}
+
+	backoff := time.Duration(cfg.ConnectBackoff)
+	for attempt := 1; ; attempt++ {
+		db, err := connect(ctx, cfg)
+		if err == nil {
+			return db, nil
+		}
+		if cfg.ConnectTimeout <= 0 {
+			return nil, fmt.Errorf("database not reachable: %w", err)
+		}
+
+		slog.Warn("Database not reachable, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("database not reachable after %d attempts: %w", attempt, err)
+		case <-time.After(backoff):
+		}
+		backoff = nextBackoff(backoff)
+	}
+}
+
+func connect(ctx context.Context, cfg config.DB) (*gorm.DB, error) {
+	// The ping below honours ctx, gorm's own ping would not
+	db, err := gorm.Open(postgres.Open(cfg.URL), &gorm.Config{DisableAutomaticPing: true})
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime))
+	sqlDB.SetConnMaxIdleTime(time.Duration(cfg.ConnMaxIdleTime))
+
+	pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	if err := sqlDB.PingContext(pingCtx); err != nil {
+		_ = sqlDB.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func nextBackoff(backoff time.Duration) time.Duration {
+	if backoff *= 2; backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
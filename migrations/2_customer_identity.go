@@ -0,0 +1,25 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+)
+
+func init() {
+	addMigration("2_customer_identity.go",
+		func(tx *gorm.DB) error {
+			// BY DEFAULT keeps explicit ids working for existing rows and fixtures,
+			// the sequence starts after the largest id already in the table.
+			result := tx.Exec(`
+			ALTER TABLE customer ALTER COLUMN id ADD GENERATED BY DEFAULT AS IDENTITY;
+			SELECT setval(pg_get_serial_sequence('customer', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM customer;
+		    `)
+			return result.Error
+		},
+		func(tx *gorm.DB) error {
+			result := tx.Exec(`
+			ALTER TABLE customer ALTER COLUMN id DROP IDENTITY IF EXISTS;
+		`)
+			return result.Error
+		},
+	)
+}
@@ -0,0 +1,50 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+)
+
+// nolint:funlen
+func init() {
+	addMigration("3_customer_search_indexes.go",
+		func(tx *gorm.DB) error {
+			// customer_search_key must stay in sync with repository.MatchNormalized
+			result := tx.Exec(`
+			CREATE OR REPLACE FUNCTION customer_search_key(value text) RETURNS text
+				LANGUAGE sql IMMUTABLE STRICT PARALLEL SAFE
+				AS $$ SELECT translate(lower(normalize(value, NFC)), 'ё', 'е') $$;
+
+			CREATE INDEX customer_first_name_lower_idx ON customer (lower(first_name) text_pattern_ops);
+			CREATE INDEX customer_last_name_lower_idx ON customer (lower(last_name) text_pattern_ops);
+			CREATE INDEX customer_patronymic_name_lower_idx ON customer (lower(patronymic_name) text_pattern_ops);
+			CREATE INDEX customer_phone_lower_idx ON customer (lower(phone) text_pattern_ops);
+			CREATE INDEX customer_email_lower_idx ON customer (lower(email) text_pattern_ops);
+
+			CREATE INDEX customer_first_name_search_key_idx ON customer (customer_search_key(first_name) text_pattern_ops);
+			CREATE INDEX customer_last_name_search_key_idx ON customer (customer_search_key(last_name) text_pattern_ops);
+			CREATE INDEX customer_patronymic_name_search_key_idx ON customer (customer_search_key(patronymic_name) text_pattern_ops);
+			CREATE INDEX customer_phone_search_key_idx ON customer (customer_search_key(phone) text_pattern_ops);
+			CREATE INDEX customer_email_search_key_idx ON customer (customer_search_key(email) text_pattern_ops);
+		    `)
+			return result.Error
+		},
+		func(tx *gorm.DB) error {
+			result := tx.Exec(`
+			DROP INDEX IF EXISTS customer_first_name_lower_idx;
+			DROP INDEX IF EXISTS customer_last_name_lower_idx;
+			DROP INDEX IF EXISTS customer_patronymic_name_lower_idx;
+			DROP INDEX IF EXISTS customer_phone_lower_idx;
+			DROP INDEX IF EXISTS customer_email_lower_idx;
+
+			DROP INDEX IF EXISTS customer_first_name_search_key_idx;
+			DROP INDEX IF EXISTS customer_last_name_search_key_idx;
+			DROP INDEX IF EXISTS customer_patronymic_name_search_key_idx;
+			DROP INDEX IF EXISTS customer_phone_search_key_idx;
+			DROP INDEX IF EXISTS customer_email_search_key_idx;
+
+			DROP FUNCTION IF EXISTS customer_search_key(text);
+		`)
+			return result.Error
+		},
+	)
+}
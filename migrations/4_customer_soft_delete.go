@@ -0,0 +1,25 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+)
+
+func init() {
+	addMigration("4_customer_soft_delete.go",
+		func(tx *gorm.DB) error {
+			result := tx.Exec(`
+			ALTER TABLE customer ADD COLUMN deleted_at timestamptz;
+			CREATE INDEX customer_deleted_at_idx ON customer (deleted_at) WHERE deleted_at IS NOT NULL;
+		    `)
+			return result.Error
+		},
+		func(tx *gorm.DB) error {
+			// Without the column soft deleted customers would come back, finish their deletion first.
+			result := tx.Exec(`
+			DELETE FROM customer WHERE deleted_at IS NOT NULL;
+			ALTER TABLE customer DROP COLUMN deleted_at;
+		`)
+			return result.Error
+		},
+	)
+}
@@ -0,0 +1,37 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+)
+
+func init() {
+	addMigration("5_customer_audit.go",
+		func(tx *gorm.DB) error {
+			// One row per deletion, customers holds the snapshots of the deleted rows.
+			// The GIN index serves lookups of the deletions that touched a customer id.
+			result := tx.Exec(`
+			CREATE TABLE customer_audit (
+				id bigint GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+				occurred_at timestamptz NOT NULL DEFAULT now(),
+				action varchar(50) NOT NULL,
+				actor varchar(200) NOT NULL,
+				request_id varchar(64),
+				prefixes text[] NOT NULL DEFAULT '{}',
+				fields text[] NOT NULL DEFAULT '{}',
+				customer_ids integer[] NOT NULL,
+				customers jsonb NOT NULL
+			);
+			CREATE INDEX customer_audit_occurred_at_idx ON customer_audit (occurred_at);
+			CREATE INDEX customer_audit_actor_idx ON customer_audit (actor, occurred_at);
+			CREATE INDEX customer_audit_customer_ids_idx ON customer_audit USING gin (customer_ids);
+		    `)
+			return result.Error
+		},
+		func(tx *gorm.DB) error {
+			result := tx.Exec(`
+			DROP TABLE customer_audit;
+		`)
+			return result.Error
+		},
+	)
+}
@@ -1,29 +1,36 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"os"
 
 	_ "github.com/lib/pq" // postgres driver
 	"github.com/go-gormigrate/gormigrate/v2"
-	"gorm.io/driver/postgres"
+	"github.com/vlegro/backend/config"
+	"github.com/vlegro/backend/database"
+	"github.com/vlegro/backend/migrations"
 	"gorm.io/gorm"
 )
 
-var migrations []*gormigrate.Migration
-
 func main() {
-	dbConnectionUrl, exists := os.LookupEnv("DB_CONNECTION_URL")
-	if !exists {
-		log.Fatal("DB_CONNECTION_URL env variable does not exist")
+	// Flags come before the command: migrations -config backend.yaml migrate
+	cfg, args, err := config.Load(os.Args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	db, err := gorm.Open(postgres.Open(dbConnectionUrl))
+	db, err := database.Open(context.Background(), cfg.DB)
 	if err != nil {
 		log.Fatalf("migration error: %v", err)
 	}
 
-	err = run(db, os.Args)
+	err = run(db, append([]string{os.Args[0]}, args...))
 	if err != nil {
 		log.Fatalf("migration error: %v", err)
 	}
@@ -35,7 +42,7 @@ func run(db *gorm.DB, args []string) error {
 		cmd = args[1]
 	}
 
-	m := gormigrate.New(db, gormigrate.DefaultOptions, migrations)
+	m := gormigrate.New(db, gormigrate.DefaultOptions, migrations.All())
 
 	switch cmd {
 	case "migrate":
@@ -73,28 +80,3 @@ func run(db *gorm.DB, args []string) error {
 
 	return nil
 }
-
-func addMigration(id string, migrate, rollback func(tx *gorm.DB) error) {
-	m := &gormigrate.Migration{
-		ID: id,
-		Migrate: func(tx *gorm.DB) error {
-			log.Printf("start migration %s\n", id)
-			err := migrate(tx)
-			if err != nil {
-				return err
-			}
-			log.Printf("end migration %s\n", id)
-			return nil
-		},
-		Rollback: func(tx *gorm.DB) error {
-			log.Printf("start rollback %s\n", id)
-			err := rollback(tx)
-			if err != nil {
-				return err
-			}
-			log.Printf("end rollback %s\n", id)
-			return nil
-		},
-	}
-	migrations = append(migrations, m)
-}
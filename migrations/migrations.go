@@ -0,0 +1,46 @@
+// Package migrations holds the schema migrations, the binary in cmd applies them.
+package migrations
+
+import (
+	"log"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+var migrations []*gormigrate.Migration
+
+// All returns the migrations in the order they are applied.
+func All() []*gormigrate.Migration {
+	return migrations
+}
+
+// LatestId is the ID of the last migration, a database at this ID has the current schema.
+func LatestId() string {
+	return migrations[len(migrations)-1].ID
+}
+
+func addMigration(id string, migrate, rollback func(tx *gorm.DB) error) {
+	m := &gormigrate.Migration{
+		ID: id,
+		Migrate: func(tx *gorm.DB) error {
+			log.Printf("start migration %s\n", id)
+			err := migrate(tx)
+			if err != nil {
+				return err
+			}
+			log.Printf("end migration %s\n", id)
+			return nil
+		},
+		Rollback: func(tx *gorm.DB) error {
+			log.Printf("start rollback %s\n", id)
+			err := rollback(tx)
+			if err != nil {
+				return err
+			}
+			log.Printf("end rollback %s\n", id)
+			return nil
+		},
+	}
+	migrations = append(migrations, m)
+}